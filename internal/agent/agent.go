@@ -1,262 +1,292 @@
 package agent
 
 import (
-    "encoding/json"
-    "fmt"
-    "math"
-    "strconv"
-    "strings"
-    "time"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 
-    "agent_challenge/internal/openrouter"
+	"agent_challenge/internal/expr"
+	"agent_challenge/internal/openrouter"
 )
 
-// GetToolDefinitions returns OpenAI-compatible tool definitions
+// defaultRegistry backs the package-level helpers for callers without a session.
+var defaultRegistry = NewRegistry()
+
+// GetToolDefinitions returns OpenAI-compatible definitions of the built-in tools
 func GetToolDefinitions() []openrouter.Tool {
-	return []openrouter.Tool{
-		{
-			Type: "function",
-			Function: openrouter.ToolFunction{
-				Name:        "get_time",
-				Description: "Возвращает текущее время в формате RFC3339 (UTC)",
-				Parameters: map[string]any{
-					"type":       "object",
-					"properties": map[string]any{},
+	return defaultRegistry.Definitions()
+}
+
+// ExecuteTool runs a built-in tool call.
+func ExecuteTool(ctx context.Context, tc openrouter.ToolCall) string {
+	return defaultRegistry.Execute(ctx, tc)
+}
+
+// ServeMCP serves the built-in tools as an MCP server over in and out until in ends.
+func ServeMCP(ctx context.Context, in io.Reader, out io.Writer) error {
+	return NewMCPServer(defaultRegistry, "agent_challenge", "1.0").Serve(ctx, in, out)
+}
+
+func registerBuiltins(r *Registry) {
+	registerDateTimeTools(r)
+	calc := newCalcEnv()
+	r.MustRegister(Tool{
+		Name:        "calc",
+		Description: calcDescription,
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"expression": map[string]any{
+					"type":        "string",
+					"description": "Выражение или несколько через ';', допускаются присваивания name = expr",
+					"minLength":   1,
 				},
-			},
-		},
-		{
-			Type: "function",
-			Function: openrouter.ToolFunction{
-				Name:        "calc",
-				Description: "Вычисляет арифметическое выражение (+,-,*,/, скобки)",
-				Parameters: map[string]any{
-					"type": "object",
-					"properties": map[string]any{
-						"expression": map[string]any{
-							"type":        "string",
-							"description": "Арифметическое выражение",
-						},
-					},
-					"required": []string{"expression"},
+				"mode": map[string]any{
+					"type":        "string",
+					"enum":        []string{"float", "exact"},
+					"description": "float (по умолчанию) — float64; exact — точная рациональная арифметика, для денежных расчётов",
+				},
+				"scale": map[string]any{
+					"type":        "integer",
+					"minimum":     0,
+					"maximum":     50,
+					"description": "Число знаков после запятой в результате (например 2 для копеек)",
+				},
+				"rounding": map[string]any{
+					"type":        "string",
+					"enum":        roundingModes,
+					"description": "Округление до scale: half_up (по умолчанию), half_even (банковское), down, up, floor, ceil",
 				},
 			},
+			"required": []string{"expression"},
 		},
-	}
+		Handler: FuncContext(calc.calculate),
+		// assignments must be seen by the calls after them
+		Sequential: true,
+	})
+	registerConvertTool(r)
 }
 
-func ExecuteTool(tc openrouter.ToolCall) string {
-	switch tc.Function.Name {
-	case "get_time":
-		return time.Now().UTC().Format(time.RFC3339)
-	case "calc":
-		var args struct {
-			Expression string `json:"expression"`
-		}
-		_ = json.Unmarshal([]byte(tc.Function.Arguments), &args)
-		res, err := evalExpression(args.Expression)
-		if err != nil {
-			return "error: " + err.Error()
-		}
-		return res
-	default:
-		return "error: unknown tool"
-	}
+type calcArgs struct {
+	Expression string `json:"expression"`
+	Mode       string `json:"mode"`
+	Scale      *int   `json:"scale"`
+	Rounding   string `json:"rounding"`
 }
 
-// evalExpression evaluates a simple arithmetic expression with + - * / and parentheses.
-// Returns string result (trimmed trailing zeros) or error.
-func evalExpression(expr string) (string, error) {
-	// Tokenize
-	tokens, err := tokenize(expr)
-	if err != nil {
-		return "", err
-	}
-	// Convert to RPN via shunting-yard
-	rpn, err := toRPN(tokens)
-	if err != nil {
-		return "", err
-	}
-	// Evaluate RPN
-	val, err := evalRPN(rpn)
-	if err != nil {
-		return "", err
-	}
-	// Format number removing trailing zeros
+// formatFloat formats val removing trailing zeros.
+func formatFloat(val float64) string {
 	res := strconv.FormatFloat(val, 'f', -1, 64)
 	if strings.Contains(res, "e+") || strings.Contains(res, "e-") {
 		res = strconv.FormatFloat(val, 'f', 10, 64)
 		res = strings.TrimRight(strings.TrimRight(res, "0"), ".")
 	}
-	return res, nil
+	return res
 }
 
-func tokenize(s string) ([]string, error) {
-	s = strings.TrimSpace(s)
-	var tokens []string
-	n := len(s)
-	for i := 0; i < n; {
-		ch := s[i]
-		switch {
-		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
-			i++
-		case ch == '+' || ch == '-' || ch == '*' || ch == '/' || ch == '(' || ch == ')':
-			// handle unary minus: if at start or after '(' or operator, treat as part of number
-			if ch == '-' {
-				if i == 0 || (len(tokens) > 0 && (tokens[len(tokens)-1] == "(" || isOperator(tokens[len(tokens)-1]))) {
-					// negative number
-					j := i + 1
-					seenDot := false
-					for j < n {
-						c := s[j]
-						if c >= '0' && c <= '9' {
-							j++
-							continue
-						}
-						if c == '.' {
-							if seenDot {
-								break
-							}
-							seenDot = true
-							j++
-							continue
-						}
-						break
-					}
-					if j > i+1 {
-						tokens = append(tokens, s[i:j])
-						i = j
-						continue
-					}
-				}
-			}
-			tokens = append(tokens, string(ch))
-			i++
-		case (ch >= '0' && ch <= '9') || ch == '.':
-			j := i
-			seenDot := false
-			for j < n {
-				c := s[j]
-				if c >= '0' && c <= '9' {
-					j++
-					continue
-				}
-				if c == '.' {
-					if seenDot {
-						break
-					}
-					seenDot = true
-					j++
-					continue
-				}
-				break
-			}
-			if j == i || (j == i+1 && s[i] == '.') {
-				return nil, fmt.Errorf("invalid number at %d", i)
-			}
-			tokens = append(tokens, s[i:j])
-			i = j
-		default:
-			return nil, fmt.Errorf("invalid character: %q", ch)
+const calcDescription = "Вычисляет арифметическое выражение: + - * / % (остаток) ^ (степень, правоассоциативная), скобки, " +
+	"функции sqrt, abs, round(x[,n]), floor, ceil, trunc, exp, ln, log(x[,base]), lg, log10, log2, " +
+	"sin, cos, tan, asin, acos, atan (радианы), pow(x,y), min(...), max(...), константы pi и e. " +
+	"Умножение можно не писать: 2(3+x), 2pi. Для денег используй mode=exact и scale=2: результат без ошибок плавающей точки. " +
+	"Переменные сохраняются между вызовами: \"rate = 0.13; income = 85000; income * rate\", ans — предыдущий результат"
+
+// calcFunction is a named function available in calc. MaxArgs < 0 means variadic.
+type calcFunction struct {
+	MinArgs, MaxArgs int
+	Fn               func(args []float64) (float64, error)
+}
+
+func unary(f func(float64) float64) calcFunction {
+	return calcFunction{MinArgs: 1, MaxArgs: 1, Fn: func(a []float64) (float64, error) { return f(a[0]), nil }}
+}
+
+var calcFunctions = map[string]calcFunction{
+	"sqrt":  unary(math.Sqrt),
+	"abs":   unary(math.Abs),
+	"floor": unary(math.Floor),
+	"ceil":  unary(math.Ceil),
+	"trunc": unary(math.Trunc),
+	"exp":   unary(math.Exp),
+	"ln":    unary(math.Log),
+	"lg":    unary(math.Log10),
+	"log10": unary(math.Log10),
+	"log2":  unary(math.Log2),
+	"sin":   unary(math.Sin),
+	"cos":   unary(math.Cos),
+	"tan":   unary(math.Tan),
+	"asin":  unary(math.Asin),
+	"acos":  unary(math.Acos),
+	"atan":  unary(math.Atan),
+	// log(x) is the natural logarithm, log(x, base) uses the given base
+	"log": {MinArgs: 1, MaxArgs: 2, Fn: func(a []float64) (float64, error) {
+		if len(a) == 1 {
+			return math.Log(a[0]), nil
 		}
-	}
-	return tokens, nil
+		return math.Log(a[0]) / math.Log(a[1]), nil
+	}},
+	// round(x) rounds half away from zero, round(x, n) keeps n decimal places
+	"round": {MinArgs: 1, MaxArgs: 2, Fn: func(a []float64) (float64, error) {
+		if len(a) == 1 {
+			return math.Round(a[0]), nil
+		}
+		if a[1] != math.Trunc(a[1]) {
+			return 0, fmt.Errorf("round: number of digits must be an integer")
+		}
+		p := math.Pow(10, a[1])
+		return math.Round(a[0]*p) / p, nil
+	}},
+	"pow": {MinArgs: 2, MaxArgs: 2, Fn: func(a []float64) (float64, error) { return math.Pow(a[0], a[1]), nil }},
+	"min": {MinArgs: 1, MaxArgs: -1, Fn: func(a []float64) (float64, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Min(m, v)
+		}
+		return m, nil
+	}},
+	"max": {MinArgs: 1, MaxArgs: -1, Fn: func(a []float64) (float64, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			m = math.Max(m, v)
+		}
+		return m, nil
+	}},
 }
 
-func precedence(op string) int {
-	switch op {
-	case "+", "-":
-		return 1
-	case "*", "/":
-		return 2
-	default:
-		return 0
-	}
+var calcConstants = map[string]float64{
+	"pi": math.Pi,
+	"e":  math.E,
 }
 
-func isOperator(tok string) bool {
-	return tok == "+" || tok == "-" || tok == "*" || tok == "/"
+// calcEval evaluates parsed calc statements against the session variables.
+// Errors are *expr.Error pointing at the offending node.
+type calcEval struct {
+	ctx  context.Context // checked before every operation, so a timed-out call stops
+	src  string
+	vars map[string]*big.Rat
 }
 
-func toRPN(tokens []string) ([]string, error) {
-	var output []string
-	var stack []string
-	for _, tok := range tokens {
-		switch {
-		case isOperator(tok):
-			for len(stack) > 0 && isOperator(stack[len(stack)-1]) && precedence(stack[len(stack)-1]) >= precedence(tok) {
-				output = append(output, stack[len(stack)-1])
-				stack = stack[:len(stack)-1]
-			}
-			stack = append(stack, tok)
-		case tok == "(":
-			stack = append(stack, tok)
-		case tok == ")":
-			found := false
-			for len(stack) > 0 {
-				t := stack[len(stack)-1]
-				stack = stack[:len(stack)-1]
-				if t == "(" {
-					found = true
-					break
-				}
-				output = append(output, t)
-			}
-			if !found {
-				return nil, fmt.Errorf("mismatched parentheses")
-			}
-		default:
-			// number
-			output = append(output, tok)
-		}
+func (ev *calcEval) errorf(n expr.Node, format string, args ...any) error {
+	return expr.Errorf(ev.src, n.Pos(), format, args...)
+}
+
+// lookup resolves a session variable; names are case-insensitive.
+func (ev *calcEval) lookup(n *expr.Ident) (*big.Rat, error) {
+	name := strings.ToLower(n.Name)
+	if v, ok := ev.vars[name]; ok {
+		return v, nil
 	}
-	for i := len(stack) - 1; i >= 0; i-- {
-		if stack[i] == "(" || stack[i] == ")" {
-			return nil, fmt.Errorf("mismatched parentheses")
-		}
-		output = append(output, stack[i])
+	if name == ansVar {
+		return nil, ev.errorf(n, "ans is not set yet: no previous result in this session")
 	}
-	return output, nil
+	return nil, ev.errorf(n, "unknown identifier %q", n.Name)
 }
 
-func evalRPN(rpn []string) (float64, error) {
-	var st []float64
-	for _, tok := range rpn {
-		if isOperator(tok) {
-			if len(st) < 2 {
-				return 0, fmt.Errorf("invalid expression")
+// function resolves a call and checks its arity.
+func (ev *calcEval) function(n *expr.Call) (string, calcFunction, error) {
+	name := strings.ToLower(n.Name)
+	fn, ok := calcFunctions[name]
+	if !ok {
+		return "", fn, ev.errorf(n, "unknown function %q", n.Name)
+	}
+	if len(n.Args) < fn.MinArgs || (fn.MaxArgs >= 0 && len(n.Args) > fn.MaxArgs) {
+		return "", fn, ev.errorf(n, "%s: wrong number of arguments: %d", name, len(n.Args))
+	}
+	return name, fn, nil
+}
+
+// zeroDivisor reports a zero right operand of / or %, naming it unless it is a literal.
+func (ev *calcEval) zeroDivisor(n *expr.Binary, op string) error {
+	if _, ok := n.Y.(*expr.Number); ok {
+		return ev.errorf(n, "%s by zero", op)
+	}
+	return ev.errorf(n, "%s by zero: %s is 0", op, expr.Format(n.Y))
+}
+
+// float evaluates n in float64.
+func (ev *calcEval) float(n expr.Node) (float64, error) {
+	if err := ev.ctx.Err(); err != nil {
+		return 0, err
+	}
+	switch n := n.(type) {
+	case *expr.Number:
+		v, err := strconv.ParseFloat(n.Text, 64)
+		if err != nil || math.IsInf(v, 0) {
+			return 0, ev.errorf(n, "number %s is out of range", n.Text)
+		}
+		return v, nil
+	case *expr.Ident:
+		if c, ok := calcConstants[strings.ToLower(n.Name)]; ok {
+			return c, nil
+		}
+		v, err := ev.lookup(n)
+		if err != nil {
+			return 0, err
+		}
+		f, _ := v.Float64()
+		return f, nil
+	case *expr.Unary:
+		x, err := ev.float(n.X)
+		if n.Op == '-' {
+			x = -x
+		}
+		return x, err
+	case *expr.Binary:
+		a, err := ev.float(n.X)
+		if err != nil {
+			return 0, err
+		}
+		b, err := ev.float(n.Y)
+		if err != nil {
+			return 0, err
+		}
+		var r float64
+		switch n.Op {
+		case '+':
+			r = a + b
+		case '-':
+			r = a - b
+		case '*':
+			r = a * b
+		case '/':
+			if b == 0 {
+				return 0, ev.zeroDivisor(n, "division")
 			}
-			b := st[len(st)-1]
-			st = st[:len(st)-1]
-			a := st[len(st)-1]
-			st = st[:len(st)-1]
-			switch tok {
-			case "+":
-				st = append(st, a+b)
-			case "-":
-				st = append(st, a-b)
-			case "*":
-				st = append(st, a*b)
-			case "/":
-				if b == 0 {
-					return 0, fmt.Errorf("division by zero")
-				}
-				st = append(st, a/b)
+			r = a / b
+		case '%':
+			if b == 0 {
+				return 0, ev.zeroDivisor(n, "modulo")
 			}
-			continue
+			r = math.Mod(a, b)
+		case '^':
+			r = math.Pow(a, b)
 		}
-		// number
-		v, err := strconv.ParseFloat(tok, 64)
+		if math.IsInf(r, 0) || math.IsNaN(r) {
+			return 0, ev.errorf(n, "%s is not a finite number", expr.Format(n))
+		}
+		return r, nil
+	case *expr.Call:
+		_, fn, err := ev.function(n)
 		if err != nil {
 			return 0, err
 		}
+		args := make([]float64, len(n.Args))
+		for i, a := range n.Args {
+			if args[i], err = ev.float(a); err != nil {
+				return 0, err
+			}
+		}
+		v, err := fn.Fn(args)
+		if err != nil {
+			return 0, ev.errorf(n, "%v", err)
+		}
 		if math.IsInf(v, 0) || math.IsNaN(v) {
-			return 0, fmt.Errorf("invalid number")
+			return 0, ev.errorf(n, "%s is not a finite number", expr.Format(n))
 		}
-		st = append(st, v)
-	}
-	if len(st) != 1 {
-		return 0, fmt.Errorf("invalid expression")
+		return v, nil
 	}
-	return st[0], nil
+	return 0, fmt.Errorf("unexpected node %T", n)
 }
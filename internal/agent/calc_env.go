@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"agent_challenge/internal/expr"
+)
+
+// ansVar holds the result of the last evaluated statement.
+const ansVar = "ans"
+
+// calcEnv is the evaluation environment of a chat session: variables assigned with
+// "name = expr" and ans survive between calc calls, so the model can build on
+// intermediate results without re-typing them. Values are kept as rationals, so
+// exact mode stays exact; float mode reads them as float64.
+type calcEnv struct {
+	mu   sync.Mutex
+	vars map[string]*big.Rat
+}
+
+func newCalcEnv() *calcEnv {
+	return &calcEnv{vars: map[string]*big.Rat{}}
+}
+
+// calculate evaluates the ';'-separated statements of args.Expression and returns the
+// value of the last one, formatted to args.Scale digits if given. Assignments take
+// effect only if every statement succeeds.
+func (e *calcEnv) calculate(ctx context.Context, args calcArgs) (string, error) {
+	rounding := args.Rounding
+	if rounding == "" {
+		rounding = RoundHalfUp
+	}
+	scale := -1
+	if args.Scale != nil {
+		scale = *args.Scale
+	}
+	exact := args.Mode == "exact"
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	vars := make(map[string]*big.Rat, len(e.vars)+1)
+	for k, v := range e.vars {
+		vars[k] = v
+	}
+	stmts, err := expr.ParseStatements(args.Expression)
+	if err != nil {
+		return "", err
+	}
+	if len(stmts) == 0 {
+		return "", fmt.Errorf("empty expression")
+	}
+	ev := &calcEval{ctx: ctx, src: args.Expression, vars: vars}
+	var (
+		last  *big.Rat
+		lastF float64
+	)
+	for _, st := range stmts {
+		name := strings.ToLower(st.Name)
+		if name != "" {
+			if err := checkAssignable(name); err != nil {
+				return "", expr.Errorf(args.Expression, st.NamePos, "%v", err)
+			}
+		}
+		if exact {
+			last, err = ev.exact(st.Value)
+		} else {
+			lastF, err = ev.float(st.Value)
+			// shortest decimal form, so 0.30000000000000004 rounds like 0.3 would
+			// and the stored value reads back as the same float64
+			last, _ = new(big.Rat).SetString(strconv.FormatFloat(lastF, 'g', -1, 64))
+		}
+		if err != nil {
+			return "", err
+		}
+		if name != "" {
+			vars[name] = last
+		}
+		vars[ansVar] = last
+	}
+	e.vars = vars
+	if !exact && scale < 0 {
+		return formatFloat(lastF), nil
+	}
+	return formatRat(last, scale, rounding), nil
+}
+
+// checkAssignable rejects names that would shadow constants, functions or ans.
+func checkAssignable(name string) error {
+	if _, ok := calcConstants[name]; ok {
+		return fmt.Errorf("cannot assign to constant %s", name)
+	}
+	if _, ok := calcFunctions[name]; ok {
+		return fmt.Errorf("cannot assign to function name %s", name)
+	}
+	if name == ansVar {
+		return fmt.Errorf("ans is set automatically and cannot be assigned")
+	}
+	return nil
+}
@@ -0,0 +1,296 @@
+package agent
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"agent_challenge/internal/expr"
+)
+
+// Exact mode evaluates calc expressions with math/big rationals, so decimal inputs
+// like 0.1 are represented exactly and money sums are reproducible to the cent.
+
+// Rounding modes for formatting exact results to a fixed scale.
+const (
+	RoundHalfUp   = "half_up"   // 2.5 → 3, -2.5 → -3
+	RoundHalfEven = "half_even" // banker's rounding: 2.5 → 2, 3.5 → 4
+	RoundDown     = "down"      // towards zero
+	RoundUp       = "up"        // away from zero
+	RoundFloor    = "floor"     // towards -∞
+	RoundCeil     = "ceil"      // towards +∞
+)
+
+var roundingModes = []string{RoundHalfUp, RoundHalfEven, RoundDown, RoundUp, RoundFloor, RoundCeil}
+
+// maxExactDigits limits the expansion of non-terminating fractions when no scale is given.
+const maxExactDigits = 20
+
+// maxRoundDigits limits the digits argument of round, positive or negative.
+const maxRoundDigits = 1000
+
+// maxExactBits limits the numerator and denominator of exact values (about 20000
+// decimal digits), so a huge power or product fails fast instead of hanging.
+const maxExactBits = 1 << 16
+
+var errTooLarge = fmt.Errorf("result too large for exact mode (over %d bits); use mode=float", maxExactBits)
+
+// ratBits is the size of r: the bit length of its numerator or denominator.
+func ratBits(r *big.Rat) int {
+	return max(r.Num().BitLen(), r.Denom().BitLen())
+}
+
+// exactFunctions are the calc functions that stay exact on rationals.
+var exactFunctions = map[string]func(args []*big.Rat) (*big.Rat, error){
+	"abs":   func(a []*big.Rat) (*big.Rat, error) { return new(big.Rat).Abs(a[0]), nil },
+	"floor": func(a []*big.Rat) (*big.Rat, error) { return roundRat(a[0], 0, RoundFloor), nil },
+	"ceil":  func(a []*big.Rat) (*big.Rat, error) { return roundRat(a[0], 0, RoundCeil), nil },
+	"trunc": func(a []*big.Rat) (*big.Rat, error) { return roundRat(a[0], 0, RoundDown), nil },
+	"round": func(a []*big.Rat) (*big.Rat, error) {
+		digits := 0
+		if len(a) == 2 {
+			if !a[1].IsInt() {
+				return nil, fmt.Errorf("round: number of digits must be an integer")
+			}
+			if a[1].Num().CmpAbs(big.NewInt(maxRoundDigits)) > 0 {
+				return nil, fmt.Errorf("round: number of digits must be between -%d and %d", maxRoundDigits, maxRoundDigits)
+			}
+			digits = int(a[1].Num().Int64())
+		}
+		return roundRat(a[0], digits, RoundHalfUp), nil
+	},
+	"pow": func(a []*big.Rat) (*big.Rat, error) { return powRat(a[0], a[1]) },
+	"min": func(a []*big.Rat) (*big.Rat, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			if v.Cmp(m) < 0 {
+				m = v
+			}
+		}
+		return m, nil
+	},
+	"max": func(a []*big.Rat) (*big.Rat, error) {
+		m := a[0]
+		for _, v := range a[1:] {
+			if v.Cmp(m) > 0 {
+				m = v
+			}
+		}
+		return m, nil
+	},
+}
+
+// exact evaluates n over rationals. Irrational constants and functions are rejected.
+func (ev *calcEval) exact(n expr.Node) (*big.Rat, error) {
+	if err := ev.ctx.Err(); err != nil {
+		return nil, err
+	}
+	switch n := n.(type) {
+	case *expr.Number:
+		v, ok := new(big.Rat).SetString(n.Text)
+		if !ok {
+			return nil, ev.errorf(n, "invalid number %q", n.Text)
+		}
+		if ratBits(v) > maxExactBits {
+			return nil, ev.errorf(n, "%v", errTooLarge)
+		}
+		return v, nil
+	case *expr.Ident:
+		if _, ok := calcConstants[strings.ToLower(n.Name)]; ok {
+			return nil, ev.errorf(n, "constant %s is irrational and not available in exact mode; use mode=float", n.Name)
+		}
+		return ev.lookup(n)
+	case *expr.Unary:
+		x, err := ev.exact(n.X)
+		if err != nil || n.Op != '-' {
+			return x, err
+		}
+		return new(big.Rat).Neg(x), nil
+	case *expr.Binary:
+		a, err := ev.exact(n.X)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ev.exact(n.Y)
+		if err != nil {
+			return nil, err
+		}
+		// a product or quotient has at most the bits of both operands: check before computing
+		if (n.Op == '*' || n.Op == '/' || n.Op == '%') && ratBits(a)+ratBits(b) > maxExactBits {
+			return nil, ev.errorf(n, "%v", errTooLarge)
+		}
+		r := new(big.Rat)
+		switch n.Op {
+		case '+':
+			r.Add(a, b)
+		case '-':
+			r.Sub(a, b)
+		case '*':
+			r.Mul(a, b)
+		case '/':
+			if b.Sign() == 0 {
+				return nil, ev.zeroDivisor(n, "division")
+			}
+			r.Quo(a, b)
+		case '%':
+			if b.Sign() == 0 {
+				return nil, ev.zeroDivisor(n, "modulo")
+			}
+			// truncated remainder, same sign as the dividend (like math.Mod)
+			q := roundRat(new(big.Rat).Quo(a, b), 0, RoundDown)
+			r.Sub(a, q.Mul(q, b))
+		case '^':
+			if r, err = powRat(a, b); err != nil {
+				return nil, ev.errorf(n, "%v", err)
+			}
+		}
+		if ratBits(r) > maxExactBits {
+			return nil, ev.errorf(n, "%v", errTooLarge)
+		}
+		return r, nil
+	case *expr.Call:
+		name, _, err := ev.function(n)
+		if err != nil {
+			return nil, err
+		}
+		fn, ok := exactFunctions[name]
+		if !ok {
+			return nil, ev.errorf(n, "%s is not available in exact mode (result is irrational in general); use mode=float", name)
+		}
+		args := make([]*big.Rat, len(n.Args))
+		for i, a := range n.Args {
+			if args[i], err = ev.exact(a); err != nil {
+				return nil, err
+			}
+		}
+		v, err := fn(args)
+		if err != nil {
+			return nil, ev.errorf(n, "%v", err)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("unexpected node %T", n)
+}
+
+// powRat raises a to an integer power b.
+func powRat(a, b *big.Rat) (*big.Rat, error) {
+	if !b.IsInt() {
+		return nil, fmt.Errorf("exact mode supports only integer exponents; use mode=float")
+	}
+	e := new(big.Int).Abs(b.Num())
+	if e.BitLen() > 14 { // |exponent| > 16383
+		return nil, fmt.Errorf("exponent too large")
+	}
+	if a.Sign() == 0 && b.Sign() < 0 {
+		return nil, fmt.Errorf("division by zero")
+	}
+	// |a^e| has about e times the bits of a; 0, 1 and -1 stay small
+	if bits := ratBits(a); bits > 1 && int64(bits-1)*e.Int64() > maxExactBits {
+		return nil, errTooLarge
+	}
+	num := new(big.Int).Exp(a.Num(), e, nil)
+	den := new(big.Int).Exp(a.Denom(), e, nil)
+	if b.Sign() < 0 {
+		num, den = den, num
+	}
+	return new(big.Rat).SetFrac(num, den), nil
+}
+
+// roundRat rounds r to scale decimal places using the given mode.
+func roundRat(r *big.Rat, scale int, mode string) *big.Rat {
+	pow := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(abs(scale))), nil)
+	x := new(big.Rat).Set(r)
+	if scale >= 0 {
+		x.Mul(x, new(big.Rat).SetInt(pow))
+	} else {
+		x.Quo(x, new(big.Rat).SetInt(pow))
+	}
+	q, rem := new(big.Int).QuoRem(x.Num(), x.Denom(), new(big.Int)) // truncated towards zero
+	if rem.Sign() != 0 {
+		neg := x.Sign() < 0
+		// compare 2*|rem| with denominator to find the distance to the halfway point
+		half := new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(x.Denom())
+		away := false
+		switch mode {
+		case RoundHalfUp:
+			away = half >= 0
+		case RoundHalfEven:
+			away = half > 0 || (half == 0 && q.Bit(0) == 1)
+		case RoundUp:
+			away = true
+		case RoundFloor:
+			away = neg
+		case RoundCeil:
+			away = !neg
+		}
+		if away {
+			if neg {
+				q.Sub(q, big.NewInt(1))
+			} else {
+				q.Add(q, big.NewInt(1))
+			}
+		}
+	}
+	out := new(big.Rat).SetInt(q)
+	if scale >= 0 {
+		return out.Quo(out, new(big.Rat).SetInt(pow))
+	}
+	return out.Mul(out, new(big.Rat).SetInt(pow))
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// formatRat renders r as a decimal. With scale >= 0 the result is rounded to exactly
+// scale digits. Otherwise terminating fractions are printed exactly and the others
+// are rounded to maxExactDigits with the exact fraction appended.
+func formatRat(r *big.Rat, scale int, mode string) string {
+	if scale >= 0 {
+		return roundRat(r, scale, mode).FloatString(scale)
+	}
+	if digits, ok := terminatingDigits(r); ok {
+		return trimZeros(r.FloatString(digits))
+	}
+	return fmt.Sprintf("%s (= %s)", trimZeros(roundRat(r, maxExactDigits, mode).FloatString(maxExactDigits)), r.RatString())
+}
+
+// terminatingDigits reports whether r has a finite decimal expansion, i.e. its
+// denominator is 2^a·5^b, and how many digits it needs.
+func terminatingDigits(r *big.Rat) (int, bool) {
+	d := new(big.Int).Set(r.Denom())
+	twos, fives := 0, 0
+	two, five := big.NewInt(2), big.NewInt(5)
+	m := new(big.Int)
+	for {
+		if q, rem := new(big.Int).QuoRem(d, two, m); rem.Sign() == 0 {
+			d, twos = q, twos+1
+			continue
+		}
+		break
+	}
+	for {
+		if q, rem := new(big.Int).QuoRem(d, five, m); rem.Sign() == 0 {
+			d, fives = q, fives+1
+			continue
+		}
+		break
+	}
+	if d.Cmp(big.NewInt(1)) != 0 {
+		return 0, false
+	}
+	return max(twos, fives), true
+}
+
+func trimZeros(s string) string {
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
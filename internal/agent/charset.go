@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Single-byte charsets common on Russian sites: bytes 0x80-0xFF map to the runes
+// of the table, the lower half is ASCII.
+var charsets = map[string][]rune{
+	"windows-1251": []rune("ЂЃ‚ѓ„…†‡€‰Љ‹ЊЌЋЏђ‘’“”•–—\u0098™љ›њќћџ\u00a0ЎўЈ¤Ґ¦§Ё©Є«¬\u00ad®Ї°±Ііґµ¶·ё№є»јЅѕї" +
+		"АБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯабвгдежзийклмнопрстуфхцчшщъыьэюя"),
+	"koi8-r": []rune("─│┌┐└┘├┤┬┴┼▀▄█▌▐░▒▓⌠■∙√≈≤≥\u00a0⌡°²·÷═║╒ё╓╔╕╖╗╘╙╚╛╜╝╞╟╠╡Ё╢╣╤╥╦╧╨╩╪╫╬©" +
+		"юабцдефгхийклмнопярстужвьызшэщчъЮАБЦДЕФГХИЙКЛМНОПЯРСТУЖВЬЫЗШЭЩЧЪ"),
+	"koi8-u": []rune("─│┌┐└┘├┤┬┴┼▀▄█▌▐░▒▓⌠■∙√≈≤≥\u00a0⌡°²·÷═║╒ёє╔ії╗╘╙╚╛ґ╝╞╟╠╡ЁЄ╣ІЇ╦╧╨╩╪Ґ╬©" +
+		"юабцдефгхийклмнопярстужвьызшэщчъЮАБЦДЕФГХИЙКЛМНОПЯРСТУЖВЬЫЗШЭЩЧЪ"),
+	"ibm866": []rune("АБВГДЕЖЗИЙКЛМНОПРСТУФХЦЧШЩЪЫЬЭЮЯабвгдежзийклмноп░▒▓│┤╡╢╖╕╣║╗╝╜╛┐" +
+		"└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀рстуфхцчшщъыьэюяЁёЄєЇїЎў°∙·√№¤■\u00a0"),
+	"windows-1252": []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008dŽ\u008f\u0090‘’“”•–—˜™š›œ\u009džŸ\u00a0¡¢£¤¥¦§¨©ª«¬\u00ad®¯°±²³´µ¶·¸¹º»¼½¾¿" +
+		"ÀÁÂÃÄÅÆÇÈÉÊËÌÍÎÏÐÑÒÓÔÕÖ×ØÙÚÛÜÝÞßàáâãäåæçèéêëìíîïðñòóôõö÷øùúûüýþÿ"),
+}
+
+// charsetAliases maps other labels of the supported charsets to their names.
+var charsetAliases = map[string]string{
+	"utf8":       "utf-8",
+	"us-ascii":   "utf-8",
+	"ascii":      "utf-8",
+	"cp1251":     "windows-1251",
+	"x-cp1251":   "windows-1251",
+	"win-1251":   "windows-1251",
+	"koi8":       "koi8-r",
+	"koi":        "koi8-r",
+	"cp866":      "ibm866",
+	"866":        "ibm866",
+	"cp1252":     "windows-1252",
+	"iso-8859-1": "windows-1252", // as browsers do
+	"latin1":     "windows-1252",
+	"iso_8859-1": "windows-1252",
+	"x-cp1252":   "windows-1252",
+}
+
+// declaredCharset matches charset="..." of a <meta> tag and encoding="..." of an XML declaration.
+var declaredCharset = regexp.MustCompile(`(?i)<meta[^>]+charset\s*=\s*["']?\s*([a-z0-9_:.-]+)|<\?xml[^>]+encoding\s*=\s*["']([a-z0-9_:.-]+)`)
+
+// sniffCharset finds the charset a document declares in its first bytes.
+func sniffCharset(body []byte) string {
+	m := declaredCharset.FindSubmatch(body[:min(len(body), 4096)])
+	if m == nil {
+		return ""
+	}
+	return string(m[1]) + string(m[2])
+}
+
+// decodeText converts body in the named charset to UTF-8. Without a charset the
+// body must be UTF-8: guessing would garble the text the model reads.
+func decodeText(body []byte, charset string) (string, error) {
+	name := strings.ToLower(strings.TrimSpace(charset))
+	if alias, ok := charsetAliases[name]; ok {
+		name = alias
+	}
+	switch name {
+	case "", "utf-8":
+		body = bytes.TrimPrefix(body, []byte("\xef\xbb\xbf"))
+		if name == "" && !validUTF8(body) {
+			return "", fmt.Errorf("text is not UTF-8 and declares no charset")
+		}
+		return strings.ToValidUTF8(string(trimPartialRune(body)), "\ufffd"), nil
+	}
+	table, ok := charsets[name]
+	if !ok {
+		return "", fmt.Errorf("charset %s is not supported", charset)
+	}
+	var b strings.Builder
+	b.Grow(len(body) * 2)
+	for _, c := range body {
+		if c < 0x80 {
+			b.WriteByte(c)
+		} else {
+			b.WriteRune(table[c-0x80])
+		}
+	}
+	return b.String(), nil
+}
+
+// validUTF8 is utf8.Valid that accepts a rune cut off at the end, as in a body
+// truncated to the size limit.
+func validUTF8(b []byte) bool {
+	return utf8.Valid(trimPartialRune(b))
+}
+
+// trimPartialRune drops the start of a multi-byte rune at the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		c := b[len(b)-i]
+		if utf8.RuneStart(c) {
+			if c >= utf8.RuneSelf && !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
@@ -0,0 +1,337 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"agent_challenge/internal/safeenv"
+)
+
+// Defaults of CommandRunner.
+const (
+	DefaultCommandTimeout = 30 * time.Second
+	MaxCommandTimeout     = 5 * time.Minute
+	DefaultMaxOutput      = 64 << 10
+)
+
+// CommandRequest is a command the model wants to run that is not pre-approved,
+// or any command when the tool policy says "ask".
+type CommandRequest struct {
+	Command string   // as written by the model
+	Args    []string // parsed argv
+	Dir     string   // working directory relative to the workspace root
+}
+
+// CommandApproval is the user's decision on a CommandRequest.
+type CommandApproval struct {
+	Approved bool
+	Always   bool   // also allow this exact command for the rest of the session
+	Reason   string // optional comment on rejection, passed back to the model
+}
+
+// CommandApprover asks the user to confirm commands that are not pre-approved.
+// Without an approver such commands are refused.
+type CommandApprover interface {
+	ApproveCommand(req CommandRequest) CommandApproval
+}
+
+// CommandRunner runs commands for the run_command tool. Commands are executed
+// directly, without a shell, inside the workspace.
+type CommandRunner struct {
+	Workspace *Workspace
+	// Allow lists pre-approved commands as words matched against argv with
+	// path.Match; a final "*" allows any further arguments: "go test *", "git status".
+	// Arguments that may reach outside the workspace or write files (absolute
+	// paths, "..", -o/--output) and wildcard arguments that are not options or
+	// paths inside the workspace are never pre-approved: the user is asked.
+	Allow     []string
+	Timeout   time.Duration // default timeout; the model may ask for up to MaxCommandTimeout
+	MaxOutput int           // bytes of combined stdout and stderr returned to the model
+	Approver  CommandApprover
+
+	mu      sync.Mutex
+	session map[string]bool // argv of commands approved with "always" in this session
+}
+
+// remembered reports whether argv was approved with "always" in this session.
+func (c *CommandRunner) remembered(args []string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.session[strings.Join(args, "\x00")]
+}
+
+// allowed reports whether argv, run in dir (relative to the workspace root),
+// matches the allowlist or a command approved for the session.
+func (c *CommandRunner) allowed(args []string, dir string) bool {
+	if c.remembered(args) {
+		return true
+	}
+	inside := func(arg string) bool {
+		_, err := c.Workspace.resolve(path.Join(dir, arg))
+		return err == nil
+	}
+	for _, p := range c.Allow {
+		if matchCommand(strings.Fields(p), args, inside) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCommand matches argv against an allowlist pattern. Arguments matched by a
+// wildcard must be options or paths for which inside is true.
+func matchCommand(pattern, args []string, inside func(arg string) bool) bool {
+	if len(args) == 0 {
+		return false
+	}
+	for _, a := range args[1:] {
+		if unsafeArg(a) {
+			return false
+		}
+	}
+	wild := func(a string) bool { return strings.HasPrefix(a, "-") || inside(a) }
+	for i, p := range pattern {
+		if p == "*" && i == len(pattern)-1 {
+			for _, a := range args[i:] {
+				if !wild(a) {
+					return false
+				}
+			}
+			return true
+		}
+		if i >= len(args) {
+			return false
+		}
+		if ok, _ := path.Match(p, args[i]); !ok {
+			return false
+		}
+		if i > 0 && strings.ContainsAny(p, "*?[") && !wild(args[i]) {
+			return false
+		}
+	}
+	return len(args) == len(pattern)
+}
+
+// unsafeArg reports whether an argument may point outside the workspace or make
+// the command write a file: absolute and home paths, "..", -o and --output.
+func unsafeArg(a string) bool {
+	if strings.HasPrefix(a, "--out") || strings.HasPrefix(a, "-o") && !strings.HasPrefix(a, "--") {
+		return true
+	}
+	value := a
+	if strings.HasPrefix(a, "-") {
+		_, v, ok := strings.Cut(a, "=")
+		if !ok {
+			return false
+		}
+		value = v
+	}
+	return filepath.IsAbs(value) || strings.HasPrefix(value, "/") || strings.HasPrefix(value, "~") || strings.Contains(value, "..")
+}
+
+// splitCommand splits a command line into argv with shell-like quoting. Pipes,
+// redirects, command chaining and substitutions are rejected: there is no shell.
+func splitCommand(s string) ([]string, error) {
+	var args []string
+	var cur strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inArg {
+				args = append(args, cur.String())
+				cur.Reset()
+				inArg = false
+			}
+		case c == '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			cur.WriteString(s[i+1 : i+1+j])
+			i += j + 1
+			inArg = true
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(`"\$`+"`", s[i+1]) >= 0 {
+					i++
+				} else if s[i] == '$' || s[i] == '`' {
+					return nil, fmt.Errorf("shell substitutions are not supported")
+				}
+				cur.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			inArg = true
+		case c == '\\' && i+1 < len(s):
+			i++
+			cur.WriteByte(s[i])
+			inArg = true
+		case strings.IndexByte("|&;<>`$(){}", c) >= 0:
+			return nil, fmt.Errorf("shell syntax %q is not supported: run a single command without pipes, redirects or variables", c)
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+	return args, nil
+}
+
+// cappedBuffer keeps the first max bytes written and counts the rest.
+type cappedBuffer struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := max(b.max-b.buf.Len(), 0); room < n {
+		b.dropped += n - room
+		p = p[:room]
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// Run executes command in dir (relative to the workspace root) and reports the
+// exit code and output. Commands off the allowlist need the approver's consent,
+// and so do all commands when the tool policy says "ask".
+func (c *CommandRunner) Run(ctx context.Context, command, dir string, timeout time.Duration) (string, error) {
+	args, err := splitCommand(command)
+	if err != nil {
+		return "", err
+	}
+	abs, err := c.Workspace.resolve(dir)
+	if err != nil {
+		return "", err
+	}
+	if fi, err := os.Stat(abs); err != nil || !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory in the workspace", dir)
+	}
+	rel := c.Workspace.rel(abs)
+	// the "ask" policy wants every command confirmed, allowlisted or not
+	confirm := mustConfirm(ctx) && !c.remembered(args)
+	if confirm || !c.allowed(args, rel) {
+		switch {
+		case c.Approver == nil && confirm:
+			return "", errors.New("command needs the user's confirmation, which is not available")
+		case c.Approver == nil:
+			return "", fmt.Errorf("command is not on the allowlist: %s", strings.Join(c.Allow, ", "))
+		}
+		ap := c.Approver.ApproveCommand(CommandRequest{Command: command, Args: args, Dir: rel})
+		if !ap.Approved {
+			msg := "пользователь не разрешил выполнить команду"
+			if ap.Reason != "" {
+				msg += ". Комментарий пользователя: " + ap.Reason
+			}
+			return msg, nil
+		}
+		if ap.Always {
+			c.mu.Lock()
+			if c.session == nil {
+				c.session = map[string]bool{}
+			}
+			c.session[strings.Join(args, "\x00")] = true
+			c.mu.Unlock()
+		}
+	}
+	if timeout <= 0 {
+		timeout = c.Timeout
+	}
+	if timeout <= 0 {
+		timeout = DefaultCommandTimeout
+	}
+	timeout = min(timeout, MaxCommandTimeout)
+	limit := c.MaxOutput
+	if limit <= 0 {
+		limit = DefaultMaxOutput
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Dir = abs
+	cmd.Env = safeenv.Environ()
+	out := &cappedBuffer{max: limit}
+	cmd.Stdout, cmd.Stderr = out, out
+	// children that keep the pipes open must not hold the tool past the timeout
+	cmd.WaitDelay = 2 * time.Second
+	start := time.Now()
+	err = cmd.Run()
+	elapsed := time.Since(start).Round(10 * time.Millisecond)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "$ %s\n", command)
+	var exitErr *exec.ExitError
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		fmt.Fprintf(&b, "прервано по таймауту %s\n", timeout)
+	case ctx.Err() != nil:
+		b.WriteString("прервано пользователем\n")
+	case errors.As(err, &exitErr):
+		fmt.Fprintf(&b, "exit code: %d (%s)\n", exitErr.ExitCode(), elapsed)
+	case err != nil:
+		return "", fmt.Errorf("cannot run %s: %w", args[0], err)
+	default:
+		fmt.Fprintf(&b, "exit code: 0 (%s)\n", elapsed)
+	}
+	b.Write(bytes.ToValidUTF8(out.buf.Bytes(), []byte("�")))
+	if out.dropped > 0 {
+		fmt.Fprintf(&b, "\n[вывод обрезан: пропущено %d байт]", out.dropped)
+	}
+	return b.String(), nil
+}
+
+// RegisterCommandTool registers run_command backed by c.
+func RegisterCommandTool(r *Registry, c *CommandRunner) {
+	allow := "нет"
+	if len(c.Allow) > 0 {
+		allow = strings.Join(c.Allow, "; ")
+	}
+	r.MustRegister(Tool{
+		Name: "run_command",
+		Description: "Выполняет одну команду (без shell: без |, >, && и переменных) в рабочей папке и возвращает код выхода и вывод. " +
+			"Без подтверждения разрешены: " + allow + " (только с путями внутри рабочей папки). Остальные команды пользователь подтверждает вручную",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"command":         map[string]any{"type": "string", "minLength": 1, "description": "Команда с аргументами, например: go test ./..."},
+				"dir":             map[string]any{"type": "string", "description": "Рабочая папка относительно корня рабочей папки, по умолчанию корень"},
+				"timeout_seconds": map[string]any{"type": "integer", "minimum": 1, "maximum": int(MaxCommandTimeout / time.Second), "description": "Таймаут в секундах"},
+			},
+			"required": []string{"command"},
+		},
+		Handler: FuncContext(func(ctx context.Context, args struct {
+			Command        string `json:"command"`
+			Dir            string `json:"dir"`
+			TimeoutSeconds int    `json:"timeout_seconds"`
+		}) (string, error) {
+			return c.Run(ctx, args.Command, args.Dir, time.Duration(args.TimeoutSeconds)*time.Second)
+		}),
+		// the command has its own timeout; confirmation waits for the user
+		Timeout:    NoTimeout,
+		Sequential: true,
+		// the runner confirms commands off the allowlist, and all commands
+		// when the policy says "ask"
+		Confirms: true,
+	})
+}
@@ -0,0 +1,217 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// countingApprover answers every question with its answer and counts them.
+type countingApprover struct {
+	answer   CommandApproval
+	commands int
+	tools    int
+}
+
+func (a *countingApprover) ApproveCommand(CommandRequest) CommandApproval {
+	a.commands++
+	return a.answer
+}
+
+func (a *countingApprover) ApproveTool(ToolRequest) ToolApproval {
+	a.tools++
+	return ToolApproval{Approved: a.answer.Approved}
+}
+
+func commandCall(command string) openrouter.ToolCall {
+	return openrouter.ToolCall{
+		ID:       "call_1",
+		Type:     "function",
+		Function: openrouter.ToolCallFunction{Name: "run_command", Arguments: `{"command":"` + command + `"}`},
+	}
+}
+
+func TestRunCommandPolicy(t *testing.T) {
+	ws, err := NewWorkspace(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		action       PolicyAction
+		answer       CommandApproval
+		command      string
+		wantCommands int
+		wantOutput   string
+	}{
+		{"allow runs an allowlisted command", PolicyAllow, CommandApproval{}, "ls", 0, "exit code: 0"},
+		{"allow asks about other commands", PolicyAllow, CommandApproval{}, "ls -R", 1, "не разрешил"},
+		{"ask asks about an allowlisted command", PolicyAsk, CommandApproval{}, "ls", 1, "не разрешил"},
+		{"ask runs an approved command", PolicyAsk, CommandApproval{Approved: true}, "ls", 1, "exit code: 0"},
+		{"deny refuses without asking", PolicyDeny, CommandApproval{Approved: true}, "ls", 0, "denied by the tool policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ap := &countingApprover{answer: tt.answer}
+			r := NewRegistry()
+			RegisterCommandTool(r, &CommandRunner{Workspace: ws, Allow: []string{"ls"}, Approver: ap})
+			r.SetPolicy(&Policy{Config: &PolicyConfig{Default: PolicyAllow, Tools: map[string]PolicyAction{"run_command": tt.action}}, Approver: ap})
+			out := r.Execute(context.Background(), commandCall(tt.command))
+			if ap.commands != tt.wantCommands || ap.tools != 0 {
+				t.Errorf("asked about %d commands and %d tool calls, want %d and 0", ap.commands, ap.tools, tt.wantCommands)
+			}
+			if !strings.Contains(out, tt.wantOutput) {
+				t.Errorf("result %q lacks %q", out, tt.wantOutput)
+			}
+		})
+	}
+}
+
+func TestRunCommandAskRemembersAlways(t *testing.T) {
+	ws, err := NewWorkspace(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ap := &countingApprover{answer: CommandApproval{Approved: true, Always: true}}
+	r := NewRegistry()
+	RegisterCommandTool(r, &CommandRunner{Workspace: ws, Allow: []string{"ls"}, Approver: ap})
+	r.SetPolicy(&Policy{Config: &PolicyConfig{Default: PolicyAllow, Tools: map[string]PolicyAction{"run_command": PolicyAsk}}, Approver: ap})
+	for range 2 {
+		r.Execute(context.Background(), commandCall("ls"))
+	}
+	if ap.commands != 1 {
+		t.Errorf("asked %d times, want once", ap.commands)
+	}
+}
+
+func TestUnsafeArg(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want bool
+	}{
+		{"src/main.go", false},
+		{".", false},
+		{"-la", false},
+		{"--stat", false},
+		{"--format=%h", false},
+		{"..", true},
+		{"../x", true},
+		{"a/../../b", true},
+		{"/etc/passwd", true},
+		{"~", true},
+		{"~/.ssh", true},
+		{"-o", true},
+		{"-ofile", true},
+		{"--output=x", true},
+		{"--output", true},
+		{"--flag=/etc", true},
+		{"--flag=../x", true},
+		{"--flag=~/x", true},
+	}
+	for _, tt := range tests {
+		if got := unsafeArg(tt.arg); got != tt.want {
+			t.Errorf("unsafeArg(%q) = %v, want %v", tt.arg, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		command string
+		want    []string
+		wantErr string
+	}{
+		{command: "ls -la  sub", want: []string{"ls", "-la", "sub"}},
+		{command: `git log --format='%h %s'`, want: []string{"git", "log", "--format=%h %s"}},
+		{command: `grep "a b" 'c d'`, want: []string{"grep", "a b", "c d"}},
+		{command: `echo "\$HOME" a\ b`, want: []string{"echo", "$HOME", "a b"}},
+		{command: "ls | wc -l", wantErr: "not supported"},
+		{command: "ls; rm -rf x", wantErr: "not supported"},
+		{command: "ls && rm x", wantErr: "not supported"},
+		{command: "ls > out.txt", wantErr: "not supported"},
+		{command: "echo $(id)", wantErr: "not supported"},
+		{command: "echo `id`", wantErr: "not supported"},
+		{command: "echo $HOME", wantErr: "not supported"},
+		{command: `echo "$HOME"`, wantErr: "substitutions"},
+		{command: `echo "$(id)"`, wantErr: "substitutions"},
+		{command: "echo \"`id`\"", wantErr: "substitutions"},
+		{command: `echo "open`, wantErr: "unterminated"},
+		{command: `echo 'open`, wantErr: "unterminated"},
+		{command: "  ", wantErr: "empty"},
+	}
+	for _, tt := range tests {
+		got, err := splitCommand(tt.command)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("splitCommand(%q) = %q, %v; want error %q", tt.command, got, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || strings.Join(got, "\x00") != strings.Join(tt.want, "\x00") {
+			t.Errorf("splitCommand(%q) = %q, %v; want %q", tt.command, got, err, tt.want)
+		}
+	}
+}
+
+func TestCommandAllowed(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"link": outside, "secret.txt": filepath.Join(outside, "secret.txt")} {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symlinks are not available: %v", err)
+		}
+	}
+	ws, err := NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &CommandRunner{Workspace: ws, Allow: []string{"ls *", "pwd", "git status", "cat *.txt"}}
+	tests := []struct {
+		command string
+		dir     string
+		want    bool
+	}{
+		{"ls", ".", true},
+		{"ls -la sub", ".", true},
+		{"ls missing", ".", true},
+		{"ls ..", ".", false},
+		{"ls sub/../..", ".", false},
+		{"ls /etc", ".", false},
+		{"ls ~", ".", false},
+		{"ls ~/.ssh", ".", false},
+		{"ls -o", ".", false},
+		{"ls --output=x", ".", false},
+		{"ls --flag=/etc", ".", false},
+		{"ls link", ".", false},   // a symlink out of the workspace
+		{"ls link/x", ".", false}, // below it
+		{"ls .", "sub", true},
+		{"pwd", ".", true},
+		{"pwd -P", ".", false}, // no trailing "*": no extra arguments
+		{"git status", ".", true},
+		{"git status --porcelain", ".", false},
+		{"git", ".", false},
+		{"cat notes.txt", ".", true},
+		{"cat secret.txt", ".", false}, // the wildcard resolves outside
+		{"cat sub/notes.txt", ".", false},
+		{"cat notes.md", ".", false},
+		{"rm notes.txt", ".", false},
+	}
+	for _, tt := range tests {
+		args, err := splitCommand(tt.command)
+		if err != nil {
+			t.Fatalf("splitCommand(%q): %v", tt.command, err)
+		}
+		if got := c.allowed(args, tt.dir); got != tt.want {
+			t.Errorf("allowed(%q in %s) = %v, want %v", tt.command, tt.dir, got, tt.want)
+		}
+	}
+}
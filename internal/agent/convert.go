@@ -0,0 +1,290 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RatesFile is the currency rate table used by the convert tool. It is read on
+// every call, so it can be updated without restarting the session.
+var RatesFile = "rates.json"
+
+// RateTable is the format of RatesFile: Rates[code] is the price of one unit of
+// the currency in Base, e.g. {"base":"RUB","rates":{"USD":81.5}}.
+type RateTable struct {
+	Date   string             `json:"date"`   // date the rates were published, YYYY-MM-DD
+	Source string             `json:"source"` // where they were taken from, e.g. "ЦБ РФ"
+	Base   string             `json:"base"`
+	Rates  map[string]float64 `json:"rates"`
+}
+
+// LoadRates reads and validates a rate table file.
+func LoadRates(path string) (*RateTable, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("currency rate table %s not found: create it (see rates.example.json) or set RATES_FILE", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t RateTable
+	if err := json.Unmarshal(b, &t); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	t.Base = strings.ToUpper(t.Base)
+	if t.Base == "" {
+		return nil, fmt.Errorf("%s: base currency is required", path)
+	}
+	rates := make(map[string]float64, len(t.Rates)+1)
+	for code, v := range t.Rates {
+		if v <= 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+			return nil, fmt.Errorf("%s: invalid rate for %s: %v", path, code, v)
+		}
+		rates[strings.ToUpper(code)] = v
+	}
+	rates[t.Base] = 1
+	t.Rates = rates
+	return &t, nil
+}
+
+// unit is a linear unit: value in the base unit of its category = value * factor.
+type unit struct {
+	category string
+	factor   float64
+}
+
+const (
+	catLength   = "length"
+	catMass     = "mass"
+	catData     = "data"
+	catDuration = "duration"
+	catTemp     = "temperature"
+)
+
+// units maps lower-case names and aliases to units. Base units: metre, kilogram,
+// byte, second. Data units are decimal (KB = 1000 B) unless binary (KiB = 1024 B);
+// bits are spelled out: bit, kbit, mbit, gbit.
+var units = map[string]unit{}
+
+func defineUnits(category string, factor float64, names ...string) {
+	for _, n := range names {
+		units[n] = unit{category, factor}
+	}
+}
+
+func init() {
+	defineUnits(catLength, 1, "m", "meter", "meters", "metre", "metres", "м", "метр", "метров", "метра")
+	defineUnits(catLength, 1e3, "km", "kilometer", "kilometers", "км", "километр", "километров", "километра")
+	defineUnits(catLength, 1e-2, "cm", "centimeter", "centimeters", "см", "сантиметр", "сантиметров")
+	defineUnits(catLength, 1e-3, "mm", "millimeter", "millimeters", "мм", "миллиметр", "миллиметров")
+	defineUnits(catLength, 1e-6, "um", "µm", "micrometer", "мкм")
+	defineUnits(catLength, 1e-9, "nm", "nanometer", "нм")
+	defineUnits(catLength, 1609.344, "mi", "mile", "miles", "миля", "мили", "миль")
+	defineUnits(catLength, 0.9144, "yd", "yard", "yards", "ярд", "ярдов")
+	defineUnits(catLength, 0.3048, "ft", "foot", "feet", "фут", "футов", "фута")
+	defineUnits(catLength, 0.0254, "in", "inch", "inches", "дюйм", "дюймов", "дюйма")
+	defineUnits(catLength, 1852, "nmi", "nautical mile", "морская миля", "морских миль")
+
+	defineUnits(catMass, 1, "kg", "kilogram", "kilograms", "кг", "килограмм", "килограммов")
+	defineUnits(catMass, 1e-3, "g", "gram", "grams", "г", "гр", "грамм", "граммов")
+	defineUnits(catMass, 1e-6, "mg", "milligram", "milligrams", "мг", "миллиграмм")
+	defineUnits(catMass, 1e3, "t", "tonne", "tonnes", "ton", "т", "тонна", "тонн", "тонны")
+	defineUnits(catMass, 100, "ц", "центнер", "центнеров")
+	defineUnits(catMass, 0.45359237, "lb", "lbs", "pound", "pounds", "фунт", "фунтов", "фунта")
+	defineUnits(catMass, 0.028349523125, "oz", "ounce", "ounces", "унция", "унций", "унции")
+	defineUnits(catMass, 2e-4, "ct", "carat", "карат")
+
+	defineUnits(catData, 1.0/8, "bit", "bits", "бит")
+	defineUnits(catData, 1, "b", "byte", "bytes", "байт", "байта", "байтов")
+	for i, p := range []struct{ dec, bin, bits, ru, ruBin string }{
+		{"kb", "kib", "kbit", "кб", "кибибайт"},
+		{"mb", "mib", "mbit", "мб", "мебибайт"},
+		{"gb", "gib", "gbit", "гб", "гибибайт"},
+		{"tb", "tib", "tbit", "тб", "тебибайт"},
+		{"pb", "pib", "pbit", "пб", "пебибайт"},
+	} {
+		defineUnits(catData, math.Pow(1000, float64(i+1)), p.dec, p.ru)
+		defineUnits(catData, math.Pow(1024, float64(i+1)), p.bin, p.ruBin)
+		defineUnits(catData, math.Pow(1000, float64(i+1))/8, p.bits)
+	}
+
+	defineUnits(catDuration, 1e-9, "ns", "нс")
+	defineUnits(catDuration, 1e-6, "us", "µs", "мкс")
+	defineUnits(catDuration, 1e-3, "ms", "мс", "millisecond", "milliseconds")
+	defineUnits(catDuration, 1, "s", "sec", "second", "seconds", "сек", "секунда", "секунд", "секунды")
+	defineUnits(catDuration, 60, "min", "minute", "minutes", "мин", "минута", "минут", "минуты")
+	defineUnits(catDuration, 3600, "h", "hr", "hour", "hours", "ч", "час", "часа", "часов")
+	defineUnits(catDuration, 86400, "d", "day", "days", "д", "дн", "день", "дня", "дней", "сутки", "суток")
+	defineUnits(catDuration, 7*86400, "wk", "week", "weeks", "нед", "неделя", "недели", "недель")
+	// average Gregorian month and year
+	defineUnits(catDuration, 365.2425*86400/12, "mo", "month", "months", "мес", "месяц", "месяца", "месяцев")
+	defineUnits(catDuration, 365.2425*86400, "y", "yr", "year", "years", "г.", "год", "года", "лет")
+}
+
+// temperatures convert to and from kelvin.
+var temperatures = map[string]struct{ toK, fromK func(float64) float64 }{
+	"c": {func(v float64) float64 { return v + 273.15 }, func(k float64) float64 { return k - 273.15 }},
+	"f": {func(v float64) float64 { return (v-32)*5/9 + 273.15 }, func(k float64) float64 { return (k-273.15)*9/5 + 32 }},
+	"k": {func(v float64) float64 { return v }, func(k float64) float64 { return k }},
+}
+
+var temperatureAliases = map[string]string{
+	"°c": "c", "celsius": "c", "цельсий": "c", "℃": "c",
+	"°f": "f", "fahrenheit": "f", "фаренгейт": "f", "℉": "f",
+	"kelvin": "k", "кельвин": "k",
+}
+
+// ambiguousUnit reports names that look the same in Latin and Cyrillic but would
+// mean different units: Latin "c" (Celsius) and Cyrillic "с" (seconds). Which
+// one the user typed depends on the keyboard layout, so both are refused.
+func ambiguousUnit(name string) bool {
+	return name == "c" || name == "с"
+}
+
+func temperatureUnit(name string) (string, bool) {
+	if a, ok := temperatureAliases[name]; ok {
+		name = a
+	}
+	_, ok := temperatures[name]
+	return name, ok
+}
+
+var currencyAliases = map[string]string{
+	"$": "USD", "€": "EUR", "₽": "RUB", "£": "GBP", "¥": "CNY", "₸": "KZT",
+	"руб": "RUB", "рубль": "RUB", "рублей": "RUB", "доллар": "USD", "долларов": "USD", "евро": "EUR", "юань": "CNY", "юаней": "CNY",
+}
+
+func currencyCode(name string) string {
+	if c, ok := currencyAliases[strings.ToLower(name)]; ok {
+		return c
+	}
+	return strings.ToUpper(name)
+}
+
+// roundSig rounds v to 12 significant digits, hiding float noise like 1.6093440000000001.
+func roundSig(v float64) float64 {
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(v, 'g', 12, 64), 64)
+	return r
+}
+
+// formatMoney prints an amount with cents, and amounts below 0.1 with three
+// significant digits so that they do not round to 0.00.
+func formatMoney(v float64) string {
+	decimals := 2
+	if a := math.Abs(v); a > 0 && a < 0.1 {
+		decimals = 2 - int(math.Floor(math.Log10(a)))
+	}
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	for decimals > 2 && strings.HasSuffix(s, "0") {
+		s, decimals = s[:len(s)-1], decimals-1
+	}
+	return s
+}
+
+// convertValue converts value between units, temperatures or currencies. Currency
+// results carry the rate, its date and source from the rate table.
+func convertValue(value float64, from, to string) (map[string]any, error) {
+	f, t := strings.ToLower(strings.TrimSpace(from)), strings.ToLower(strings.TrimSpace(to))
+	for i, u := range []string{f, t} {
+		if ambiguousUnit(u) {
+			return nil, fmt.Errorf("unit %q is ambiguous: write °C or celsius for degrees Celsius, s or сек for seconds", []string{from, to}[i])
+		}
+	}
+	if fu, ok := units[f]; ok {
+		tu, ok := units[t]
+		if !ok {
+			return nil, fmt.Errorf("unknown unit %q", to)
+		}
+		if fu.category != tu.category {
+			return nil, fmt.Errorf("cannot convert %s (%s) to %s (%s)", from, fu.category, to, tu.category)
+		}
+		return map[string]any{
+			"value": value, "from": from, "to": to, "category": fu.category,
+			"result": formatFloat(roundSig(value * fu.factor / tu.factor)),
+		}, nil
+	}
+	if fk, ok := temperatureUnit(f); ok {
+		tk, ok := temperatureUnit(t)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert temperature to %q: use °C, °F or K", to)
+		}
+		k := temperatures[fk].toK(value)
+		if k < 0 {
+			return nil, fmt.Errorf("%v %s is below absolute zero", value, from)
+		}
+		return map[string]any{
+			"value": value, "from": from, "to": to, "category": catTemp,
+			"result": formatFloat(roundSig(temperatures[tk].fromK(k))),
+		}, nil
+	}
+	if _, ok := units[t]; ok {
+		return nil, fmt.Errorf("unknown unit %q", from)
+	}
+	rates, err := LoadRates(RatesFile)
+	if err != nil {
+		return nil, err
+	}
+	fc, tc := currencyCode(from), currencyCode(to)
+	for _, c := range []string{fc, tc} {
+		if _, ok := rates.Rates[c]; !ok {
+			return nil, fmt.Errorf("unknown unit or currency %q; currencies in %s: %s", c, RatesFile, strings.Join(currencyCodes(rates), ", "))
+		}
+	}
+	rate := rates.Rates[fc] / rates.Rates[tc]
+	return map[string]any{
+		"value": value, "from": fc, "to": tc, "category": "currency",
+		"result":     formatMoney(value * rate),
+		"rate":       formatFloat(roundSig(rate)),
+		"rates_date": rates.Date,
+		"source":     rates.Source,
+		"rates_file": RatesFile,
+	}, nil
+}
+
+func currencyCodes(t *RateTable) []string {
+	codes := make([]string, 0, len(t.Rates))
+	for c := range t.Rates {
+		codes = append(codes, c)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+const convertDescription = "Переводит величину между единицами: длина (m, km, cm, mm, mi, yd, ft, in, nmi), " +
+	"масса (kg, g, mg, t, lb, oz), данные (B, KB, MB, GB, TB — по 1000; KiB, MiB, GiB, TiB — по 1024; bit, Mbit, Gbit), " +
+	"время (ms, s, min, h, d, week, month, year), температура (°C, °F, K; не просто C), а также валюты (USD, EUR, RUB, ...) " +
+	"по локальной таблице курсов — в ответе есть дата курсов и источник, сообщи их пользователю"
+
+func registerConvertTool(r *Registry) {
+	r.MustRegister(Tool{
+		Name:        "convert",
+		Description: convertDescription,
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"value": map[string]any{"type": "number", "description": "Значение"},
+				"from":  map[string]any{"type": "string", "minLength": 1, "description": "Исходная единица или код валюты"},
+				"to":    map[string]any{"type": "string", "minLength": 1, "description": "Целевая единица или код валюты"},
+			},
+			"required": []string{"value", "from", "to"},
+		},
+		Handler: Func(func(args struct {
+			Value float64 `json:"value"`
+			From  string  `json:"from"`
+			To    string  `json:"to"`
+		}) (string, error) {
+			res, err := convertValue(args.Value, args.From, args.To)
+			if err != nil {
+				return "", err
+			}
+			return jsonResult(res)
+		}),
+	})
+}
@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConvertAmbiguousUnits(t *testing.T) {
+	// Latin c and Cyrillic с look the same but meant Celsius and seconds
+	for _, u := range []string{"c", "C", "с", "С"} {
+		if _, err := convertValue(5, u, "s"); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("convert from %q: error = %v, want ambiguous", u, err)
+		}
+		if _, err := convertValue(5, "K", u); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+			t.Errorf("convert to %q: error = %v, want ambiguous", u, err)
+		}
+	}
+	tests := []struct {
+		from, to string
+		value    float64
+		want     string
+	}{
+		{"°C", "°F", 100, "212"},
+		{"celsius", "K", 0, "273.15"},
+		{"℃", "F", 37, "98.6"},
+		{"s", "min", 90, "1.5"},
+		{"сек", "ms", 2, "2000"},
+		{"секунд", "min", 120, "2"},
+	}
+	for _, tt := range tests {
+		res, err := convertValue(tt.value, tt.from, tt.to)
+		if err != nil {
+			t.Errorf("convert %v %s to %s: %v", tt.value, tt.from, tt.to, err)
+			continue
+		}
+		if res["result"] != tt.want {
+			t.Errorf("convert %v %s to %s = %v, want %s", tt.value, tt.from, tt.to, res["result"], tt.want)
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeNow is the clock of the date tools.
+var timeNow = time.Now
+
+// loadLocation resolves an IANA zone name ("Europe/Moscow") or a fixed offset
+// ("UTC+3", "+05:30", "GMT-4"). An empty name is UTC.
+func loadLocation(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return time.UTC, nil
+	}
+	if m := offsetRe.FindStringSubmatch(strings.ToUpper(name)); m != nil {
+		h, _ := strconv.Atoi(m[2])
+		mins, _ := strconv.Atoi(m[3])
+		if h > 14 || mins > 59 {
+			return nil, fmt.Errorf("invalid UTC offset %q", name)
+		}
+		secs := h*3600 + mins*60
+		if m[1] == "-" {
+			secs = -secs
+		}
+		return time.FixedZone(fmt.Sprintf("UTC%s%02d:%02d", m[1], h, mins), secs), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q: use an IANA name like Europe/Moscow or an offset like UTC+3", name)
+	}
+	return loc, nil
+}
+
+var offsetRe = regexp.MustCompile(`^(?:UTC|GMT)?\s*([+-])(\d{1,2})(?::?(\d{2}))?$`)
+
+// dateLayouts are the absolute formats accepted by parseDate, tried in order.
+// Layouts without an offset are interpreted in the requested zone.
+var dateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/01/02",
+	"02.01.2006 15:04:05",
+	"02.01.2006 15:04",
+	"02.01.2006",
+	"2.1.2006",
+	"January 2, 2006 15:04",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"January 2 2006",
+	"2 January 2006",
+	"2 Jan 2006",
+	time.RFC1123Z,
+	time.RFC1123,
+}
+
+var (
+	clockSuffixRe = regexp.MustCompile(`^(.*?)(?:\s*,?\s+(?:в|at))?\s+(\d{1,2}):(\d{2})$`)
+	relativeRe    = regexp.MustCompile(`^(?:(?:in|через)\s+(\d+\s+)?([a-zа-яё]+)|(\d+)\s+([a-zа-яё]+)\s+(?:ago|назад))$`)
+	dayMonthRe    = regexp.MustCompile(`^(\d{1,2})\s+([a-zа-яё]+)\.?(?:\s+(\d{4}))?(?:\s*(?:г\.?|года))?$`)
+	monthDayRe    = regexp.MustCompile(`^([a-z]+)\.?\s+(\d{1,2})(?:st|nd|rd|th)?(?:,?\s+(\d{4}))?$`)
+)
+
+// dayWords are relative day names, as an offset in days from today.
+var dayWords = map[string]int{
+	"today": 0, "сегодня": 0,
+	"tomorrow": 1, "завтра": 1,
+	"yesterday": -1, "вчера": -1,
+	"day after tomorrow": 2, "послезавтра": 2,
+	"day before yesterday": -2, "позавчера": -2,
+}
+
+// monthPrefixes map English and Russian month names (any case form) to months.
+// "мар" must come before "ма" (мая, май).
+var monthPrefixes = []struct {
+	prefix string
+	month  time.Month
+}{
+	{"jan", time.January}, {"feb", time.February}, {"mar", time.March}, {"apr", time.April},
+	{"may", time.May}, {"jun", time.June}, {"jul", time.July}, {"aug", time.August},
+	{"sep", time.September}, {"oct", time.October}, {"nov", time.November}, {"dec", time.December},
+	{"янв", time.January}, {"фев", time.February}, {"мар", time.March}, {"апр", time.April},
+	{"ма", time.May}, {"июн", time.June}, {"июл", time.July}, {"авг", time.August},
+	{"сен", time.September}, {"окт", time.October}, {"ноя", time.November}, {"дек", time.December},
+}
+
+var weekdayPrefixes = []struct {
+	prefix string
+	day    time.Weekday
+}{
+	{"mon", time.Monday}, {"tue", time.Tuesday}, {"wed", time.Wednesday}, {"thu", time.Thursday},
+	{"fri", time.Friday}, {"sat", time.Saturday}, {"sun", time.Sunday},
+	{"понед", time.Monday}, {"вторн", time.Tuesday}, {"сред", time.Wednesday}, {"четв", time.Thursday},
+	{"пятн", time.Friday}, {"субб", time.Saturday}, {"воскр", time.Sunday},
+}
+
+func lookupMonth(word string) (time.Month, bool) {
+	for _, m := range monthPrefixes {
+		if strings.HasPrefix(word, m.prefix) {
+			return m.month, true
+		}
+	}
+	return 0, false
+}
+
+func lookupWeekday(word string) (time.Weekday, bool) {
+	for _, d := range weekdayPrefixes {
+		if strings.HasPrefix(word, d.prefix) {
+			return d.day, true
+		}
+	}
+	return 0, false
+}
+
+// parseDate parses an absolute date ("2026-10-16", "16.10.2026 18:00", RFC3339,
+// "16 октября 2026", "Oct 16") or a human one relative to now ("завтра в 10:00",
+// "через 3 дня", "2 weeks ago", "next friday", "в следующий понедельник").
+// Dates without a time are midnight in loc.
+func parseDate(s string, loc *time.Location, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	now = now.In(loc)
+	text := strings.Join(strings.Fields(strings.ToLower(s)), " ")
+	switch text {
+	case "now", "сейчас":
+		return now, nil
+	case "":
+		return time.Time{}, fmt.Errorf("empty date")
+	}
+	// optional clock time at the end: "завтра в 15:30", "next monday at 9:00", "15:30"
+	hour, minute := -1, 0
+	if m := clockSuffixRe.FindStringSubmatch(" " + text); m != nil {
+		hour, _ = strconv.Atoi(m[2])
+		minute, _ = strconv.Atoi(m[3])
+		if hour > 23 || minute > 59 {
+			return time.Time{}, fmt.Errorf("invalid time of day in %q", s)
+		}
+		text = strings.TrimSpace(m[1])
+		if text == "" {
+			text = "today"
+		}
+	}
+	t, ok, err := parseDayText(text, loc, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !ok {
+		return time.Time{}, fmt.Errorf("cannot parse date %q: use YYYY-MM-DD, DD.MM.YYYY, RFC3339 or words like \"завтра\", \"через 3 дня\", \"next friday\"", s)
+	}
+	if hour >= 0 {
+		t = time.Date(t.Year(), t.Month(), t.Day(), hour, minute, 0, 0, loc)
+	}
+	return t, nil
+}
+
+// parseDayText handles the human forms of parseDate without a clock time.
+func parseDayText(text string, loc *time.Location, now time.Time) (time.Time, bool, error) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	if d, ok := dayWords[text]; ok {
+		return today.AddDate(0, 0, d), true, nil
+	}
+	if m := relativeRe.FindStringSubmatch(text); m != nil {
+		n, unit, sign := 1, m[2], 1
+		if m[1] != "" {
+			n, _ = strconv.Atoi(strings.TrimSpace(m[1]))
+		}
+		if m[3] != "" {
+			n, _ = strconv.Atoi(m[3])
+			unit, sign = m[4], -1
+		}
+		return addUnits(now, today, sign*n, unit)
+	}
+	if m := dayMonthRe.FindStringSubmatch(text); m != nil {
+		if month, ok := lookupMonth(m[2]); ok {
+			return civilDate(m[3], month, m[1], loc, now)
+		}
+	}
+	if m := monthDayRe.FindStringSubmatch(text); m != nil {
+		if month, ok := lookupMonth(m[1]); ok {
+			return civilDate(m[3], month, m[2], loc, now)
+		}
+	}
+	// weekdays: "friday" is today or later, "next friday" is strictly after today
+	words := strings.Fields(text)
+	if len(words) > 0 && (words[0] == "в" || words[0] == "во" || words[0] == "on") {
+		words = words[1:]
+	}
+	next := false
+	if len(words) == 2 && (words[0] == "next" || strings.HasPrefix(words[0], "следующ") || words[0] == "this" || strings.HasPrefix(words[0], "эт")) {
+		next = words[0] == "next" || strings.HasPrefix(words[0], "следующ")
+		words = words[1:]
+	}
+	if len(words) == 1 {
+		if wd, ok := lookupWeekday(words[0]); ok {
+			d := (int(wd) - int(today.Weekday()) + 7) % 7
+			if d == 0 && next {
+				d = 7
+			}
+			return today.AddDate(0, 0, d), true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// addUnits shifts by n units named in English or Russian (any case form).
+// Day and larger units start from today's midnight, hours and minutes from now.
+func addUnits(now, today time.Time, n int, unit string) (time.Time, bool, error) {
+	switch {
+	case strings.HasPrefix(unit, "min"), strings.HasPrefix(unit, "мин"):
+		return now.Add(time.Duration(n) * time.Minute), true, nil
+	case strings.HasPrefix(unit, "hour"), strings.HasPrefix(unit, "час"):
+		return now.Add(time.Duration(n) * time.Hour), true, nil
+	case strings.HasPrefix(unit, "day"), strings.HasPrefix(unit, "дн"), strings.HasPrefix(unit, "ден"), unit == "сутки", unit == "суток":
+		return today.AddDate(0, 0, n), true, nil
+	case strings.HasPrefix(unit, "week"), strings.HasPrefix(unit, "нед"):
+		return today.AddDate(0, 0, 7*n), true, nil
+	case strings.HasPrefix(unit, "month"), strings.HasPrefix(unit, "мес"):
+		return addMonths(today, n), true, nil
+	case strings.HasPrefix(unit, "year"), strings.HasPrefix(unit, "год"), unit == "лет":
+		return addMonths(today, 12*n), true, nil
+	}
+	return time.Time{}, false, fmt.Errorf("unknown time unit %q", unit)
+}
+
+// civilDate builds a date from parsed parts; a missing year means the current one.
+func civilDate(year string, month time.Month, day string, loc *time.Location, now time.Time) (time.Time, bool, error) {
+	y := now.Year()
+	if year != "" {
+		y, _ = strconv.Atoi(year)
+	}
+	d, _ := strconv.Atoi(day)
+	if d < 1 || d > daysIn(y, month) {
+		return time.Time{}, false, fmt.Errorf("%s %d has no day %d", month, y, d)
+	}
+	return time.Date(y, month, d, 0, 0, 0, 0, loc), true, nil
+}
+
+func daysIn(year int, month time.Month) int {
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+// addMonths adds n months, clamping the day to the end of the target month:
+// Jan 31 + 1 month is Feb 28 (29), not Mar 3 as with time.AddDate.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month(), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location()).AddDate(0, n, 0)
+	day := min(t.Day(), daysIn(first.Year(), first.Month()))
+	return first.AddDate(0, 0, day-1)
+}
@@ -0,0 +1,346 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+	_ "time/tzdata" // embedded zone database: the tools work without system tzdata
+)
+
+// dateInfo is the result of the date tools.
+type dateInfo struct {
+	Time      string `json:"time"` // RFC3339
+	Date      string `json:"date"`
+	Weekday   string `json:"weekday"`
+	Timezone  string `json:"timezone"`
+	ISOWeek   int    `json:"iso_week"`
+	DayOfYear int    `json:"day_of_year"`
+}
+
+func newDateInfo(t time.Time) dateInfo {
+	_, week := t.ISOWeek()
+	return dateInfo{
+		Time:      t.Format(time.RFC3339),
+		Date:      t.Format(time.DateOnly),
+		Weekday:   t.Weekday().String(),
+		Timezone:  t.Location().String(),
+		ISOWeek:   week,
+		DayOfYear: t.YearDay(),
+	}
+}
+
+func jsonResult(v any) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// holidaySet parses YYYY-MM-DD dates that are not business days.
+func holidaySet(dates []string) (map[string]bool, error) {
+	set := make(map[string]bool, len(dates))
+	for _, d := range dates {
+		t, err := time.Parse(time.DateOnly, d)
+		if err != nil {
+			return nil, fmt.Errorf("holiday %q: expected YYYY-MM-DD", d)
+		}
+		set[t.Format(time.DateOnly)] = true
+	}
+	return set, nil
+}
+
+func isBusinessDay(t time.Time, holidays map[string]bool) bool {
+	wd := t.Weekday()
+	return wd != time.Saturday && wd != time.Sunday && !holidays[t.Format(time.DateOnly)]
+}
+
+// addBusinessDays moves n business days forward (or back if n < 0), keeping the time of day.
+func addBusinessDays(t time.Time, n int, holidays map[string]bool) time.Time {
+	step := 1
+	if n < 0 {
+		step, n = -1, -n
+	}
+	for n > 0 {
+		t = t.AddDate(0, 0, step)
+		if isBusinessDay(t, holidays) {
+			n--
+		}
+	}
+	return t
+}
+
+// civilDays is the number of calendar days from a to b, ignoring the time of day and DST.
+func civilDays(a, b time.Time) int {
+	ua := time.Date(a.Year(), a.Month(), a.Day(), 0, 0, 0, 0, time.UTC)
+	ub := time.Date(b.Year(), b.Month(), b.Day(), 0, 0, 0, 0, time.UTC)
+	return int(ub.Sub(ua).Hours() / 24)
+}
+
+// countBusinessDays counts business days in (a, b], negated if b is before a,
+// so that addBusinessDays(a, n) lands on b for n = countBusinessDays(a, b).
+func countBusinessDays(a, b time.Time, holidays map[string]bool) int {
+	sign := 1
+	if b.Before(a) {
+		a, b, sign = b, a, -1
+	}
+	n := 0
+	for d, days := a, civilDays(a, b); days > 0; days-- {
+		d = d.AddDate(0, 0, 1)
+		if isBusinessDay(d, holidays) {
+			n++
+		}
+	}
+	return sign * n
+}
+
+// calendarSpan splits the civil period from a to b (a <= b) into whole years and
+// months, counted with addMonths, and the remaining days.
+func calendarSpan(a, b time.Time) (years, months, days int) {
+	total := (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+	if civilDays(addMonths(a, total), b) < 0 {
+		total--
+	}
+	return total / 12, total % 12, civilDays(addMonths(a, total), b)
+}
+
+var (
+	timezoneParam = map[string]any{
+		"type":        "string",
+		"description": "IANA-зона, например Europe/Moscow, Asia/Almaty, America/New_York, или смещение UTC+3. По умолчанию UTC",
+	}
+	holidaysParam = map[string]any{
+		"type":        "array",
+		"items":       map[string]any{"type": "string"},
+		"description": "Праздничные нерабочие дни YYYY-MM-DD, исключаются из рабочих дней (субботы и воскресенья исключаются всегда)",
+	}
+)
+
+func dateParam(description string) map[string]any {
+	return map[string]any{
+		"type":        "string",
+		"minLength":   1,
+		"description": description + ": YYYY-MM-DD, DD.MM.YYYY, RFC3339, \"16 октября 2026\", \"завтра в 10:00\", \"через 3 дня\", \"next friday\"",
+	}
+}
+
+func intParam(description string) map[string]any {
+	return map[string]any{"type": "integer", "description": description}
+}
+
+// registerDateTimeTools registers get_time and the timezone-aware date tools.
+// Zones come from the embedded tzdata, so results do not depend on the host.
+func registerDateTimeTools(r *Registry) {
+	r.MustRegister(Tool{
+		Name:        "get_time",
+		Description: "Возвращает текущее время в формате RFC3339 (по умолчанию UTC, или в указанной зоне)",
+		Parameters: map[string]any{
+			"type":       "object",
+			"properties": map[string]any{"timezone": timezoneParam},
+		},
+		Handler: Func(func(args struct {
+			Timezone string `json:"timezone"`
+		}) (string, error) {
+			loc, err := loadLocation(args.Timezone)
+			if err != nil {
+				return "", err
+			}
+			return timeNow().In(loc).Format(time.RFC3339), nil
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "convert_time",
+		Description: "Переводит время из одной часовой зоны в другую",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"time":          dateParam("Время; если в нём нет смещения, оно считается в from_timezone"),
+				"from_timezone": timezoneParam,
+				"to_timezone":   timezoneParam,
+			},
+			"required": []string{"time", "to_timezone"},
+		},
+		Handler: Func(func(args struct {
+			Time         string `json:"time"`
+			FromTimezone string `json:"from_timezone"`
+			ToTimezone   string `json:"to_timezone"`
+		}) (string, error) {
+			from, err := loadLocation(args.FromTimezone)
+			if err != nil {
+				return "", err
+			}
+			to, err := loadLocation(args.ToTimezone)
+			if err != nil {
+				return "", err
+			}
+			t, err := parseDate(args.Time, from, timeNow())
+			if err != nil {
+				return "", err
+			}
+			return jsonResult(newDateInfo(t.In(to)))
+		}),
+	})
+	r.MustRegister(Tool{
+		Name: "date_add",
+		Description: "Прибавляет к дате годы, месяцы, недели, дни, рабочие дни, часы и минуты (отрицательные значения вычитают). " +
+			"Месяцы не перескакивают через конец месяца: 31 января + 1 месяц = 28/29 февраля",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"date":     dateParam("Исходная дата"),
+				"timezone": timezoneParam,
+				"years":    intParam("Годы"),
+				"months":   intParam("Месяцы"),
+				"weeks":    intParam("Недели"),
+				"days":     intParam("Календарные дни"),
+				"business_days": map[string]any{
+					"type":        "integer",
+					"minimum":     -10000,
+					"maximum":     10000,
+					"description": "Рабочие дни (пн–пт без праздников из holidays)",
+				},
+				"hours":    intParam("Часы"),
+				"minutes":  intParam("Минуты"),
+				"holidays": holidaysParam,
+			},
+			"required": []string{"date"},
+		},
+		Handler: Func(func(args struct {
+			Date         string   `json:"date"`
+			Timezone     string   `json:"timezone"`
+			Years        int      `json:"years"`
+			Months       int      `json:"months"`
+			Weeks        int      `json:"weeks"`
+			Days         int      `json:"days"`
+			BusinessDays int      `json:"business_days"`
+			Hours        int      `json:"hours"`
+			Minutes      int      `json:"minutes"`
+			Holidays     []string `json:"holidays"`
+		}) (string, error) {
+			loc, err := loadLocation(args.Timezone)
+			if err != nil {
+				return "", err
+			}
+			holidays, err := holidaySet(args.Holidays)
+			if err != nil {
+				return "", err
+			}
+			t, err := parseDate(args.Date, loc, timeNow())
+			if err != nil {
+				return "", err
+			}
+			t = addMonths(t, 12*args.Years+args.Months)
+			t = t.AddDate(0, 0, 7*args.Weeks+args.Days)
+			t = addBusinessDays(t, args.BusinessDays, holidays)
+			t = t.Add(time.Duration(args.Hours)*time.Hour + time.Duration(args.Minutes)*time.Minute)
+			return jsonResult(newDateInfo(t))
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "date_diff",
+		Description: "Разница между двумя датами: календарные и рабочие дни, часы, разбивка на годы/месяцы/дни",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"from":     dateParam("Начальная дата"),
+				"to":       dateParam("Конечная дата"),
+				"timezone": timezoneParam,
+				"holidays": holidaysParam,
+			},
+			"required": []string{"from", "to"},
+		},
+		Handler: Func(func(args struct {
+			From     string   `json:"from"`
+			To       string   `json:"to"`
+			Timezone string   `json:"timezone"`
+			Holidays []string `json:"holidays"`
+		}) (string, error) {
+			loc, err := loadLocation(args.Timezone)
+			if err != nil {
+				return "", err
+			}
+			holidays, err := holidaySet(args.Holidays)
+			if err != nil {
+				return "", err
+			}
+			now := timeNow()
+			from, err := parseDate(args.From, loc, now)
+			if err != nil {
+				return "", fmt.Errorf("from: %w", err)
+			}
+			to, err := parseDate(args.To, loc, now)
+			if err != nil {
+				return "", fmt.Errorf("to: %w", err)
+			}
+			// the span is computed in the zone of "from" so both ends use the same calendar
+			to = to.In(from.Location())
+			a, b := from, to
+			if b.Before(a) {
+				a, b = b, a
+			}
+			years, months, days := calendarSpan(a, b)
+			return jsonResult(map[string]any{
+				"from":          from.Format(time.RFC3339),
+				"to":            to.Format(time.RFC3339),
+				"calendar_days": civilDays(from, to),
+				"business_days": countBusinessDays(from, to, holidays),
+				"hours":         math.Round(to.Sub(from).Hours()*100) / 100,
+				"negative":      to.Before(from),
+				"span":          map[string]int{"years": years, "months": months, "days": days},
+			})
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "day_of_week",
+		Description: "День недели, номер ISO-недели и день года для даты",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"date":     dateParam("Дата"),
+				"timezone": timezoneParam,
+			},
+			"required": []string{"date"},
+		},
+		Handler: Func(func(args struct {
+			Date     string `json:"date"`
+			Timezone string `json:"timezone"`
+		}) (string, error) {
+			loc, err := loadLocation(args.Timezone)
+			if err != nil {
+				return "", err
+			}
+			t, err := parseDate(args.Date, loc, timeNow())
+			if err != nil {
+				return "", err
+			}
+			return jsonResult(newDateInfo(t))
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "parse_date",
+		Description: "Разбирает дату, записанную человеком (\"завтра в 15:00\", \"через 2 недели\", \"в следующий понедельник\", \"16 октября\"), относительно текущего времени в зоне",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"text":     dateParam("Текст даты"),
+				"timezone": timezoneParam,
+			},
+			"required": []string{"text"},
+		},
+		Handler: Func(func(args struct {
+			Text     string `json:"text"`
+			Timezone string `json:"timezone"`
+		}) (string, error) {
+			loc, err := loadLocation(args.Timezone)
+			if err != nil {
+				return "", err
+			}
+			t, err := parseDate(args.Text, loc, timeNow())
+			if err != nil {
+				return "", err
+			}
+			return jsonResult(newDateInfo(t))
+		}),
+	})
+}
@@ -0,0 +1,201 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContext is the number of unchanged lines shown around each change.
+const diffContext = 3
+
+// maxDiffLines and maxEditDistance bound the Myers search; beyond them the
+// change is shown as a full replacement.
+const (
+	maxDiffLines    = 20000
+	maxEditDistance = 2000
+)
+
+type diffOp struct {
+	op   byte // ' ', '-' or '+'
+	text string
+}
+
+// splitLines splits s into lines that keep their "\n", so a missing final
+// newline is a difference of its own.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns a shortest edit script from a to b (Myers' algorithm).
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	replace := func() []diffOp {
+		ops := make([]diffOp, 0, n+m)
+		for _, l := range a {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range b {
+			ops = append(ops, diffOp{'+', l})
+		}
+		return ops
+	}
+	if n+m > maxDiffLines {
+		return replace()
+	}
+	total := n + m
+	off := total + 1
+	v := make([]int, 2*total+3)
+	// trace[d] holds v[-d-1..d+1] as it was before step d
+	var trace [][]int
+search:
+	for d := 0; ; d++ {
+		if d > maxEditDistance {
+			return replace()
+		}
+		trace = append(trace, append([]int(nil), v[off-d-1:off+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[off+k-1] < v[off+k+1]) {
+				x = v[off+k+1]
+			} else {
+				x = v[off+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[off+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+	// walk the trace back from the end to recover the path
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		w := trace[d]
+		at := func(k int) int { return w[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			ops = append(ops, diffOp{' ', a[x-1]})
+			x--
+			y--
+		}
+		if d > 0 {
+			if x == prevX {
+				ops = append(ops, diffOp{'+', b[y-1]})
+			} else {
+				ops = append(ops, diffOp{'-', a[x-1]})
+			}
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// diffStat counts added and removed lines.
+func diffStat(ops []diffOp) (added, removed int) {
+	for _, o := range ops {
+		switch o.op {
+		case '+':
+			added++
+		case '-':
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// UnifiedDiff renders the change from old to new as a unified diff of path.
+// A nil old means the file is created. The result is empty if nothing changed.
+func UnifiedDiff(path string, old, new []byte) string {
+	ops := diffLines(splitLines(string(old)), splitLines(string(new)))
+	if a, r := diffStat(ops); a == 0 && r == 0 {
+		return ""
+	}
+	var b strings.Builder
+	from := "a/" + path
+	if old == nil {
+		from = "/dev/null"
+	}
+	fmt.Fprintf(&b, "--- %s\n+++ b/%s\n", from, path)
+	// line numbers before each op, 0-based
+	aLine := make([]int, len(ops)+1)
+	bLine := make([]int, len(ops)+1)
+	for i, o := range ops {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if o.op != '+' {
+			aLine[i+1]++
+		}
+		if o.op != '-' {
+			bLine[i+1]++
+		}
+	}
+	floor := 0
+	for i := 0; i < len(ops); {
+		for i < len(ops) && ops[i].op == ' ' {
+			i++
+		}
+		if i == len(ops) {
+			break
+		}
+		start := max(i-diffContext, floor)
+		// extend the hunk while the gaps between changes are short enough to merge
+		end := i
+		for end < len(ops) {
+			if ops[end].op != ' ' {
+				end++
+				continue
+			}
+			run := end
+			for run < len(ops) && ops[run].op == ' ' {
+				run++
+			}
+			if run == len(ops) || run-end > 2*diffContext {
+				end = min(end+diffContext, run)
+				break
+			}
+			end = run
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(aLine[start], aLine[end]-aLine[start]), hunkRange(bLine[start], bLine[end]-bLine[start]))
+		for _, o := range ops[start:end] {
+			b.WriteByte(o.op)
+			b.WriteString(o.text)
+			if !strings.HasSuffix(o.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i, floor = end, end
+	}
+	return b.String()
+}
+
+func hunkRange(start, n int) string {
+	if n == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if n == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, n)
+}
@@ -0,0 +1,343 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"agent_challenge/internal/htmltext"
+	"agent_challenge/internal/httpx"
+)
+
+// Defaults of Fetcher.
+const (
+	DefaultFetchMaxBytes = 2 << 20
+	DefaultFetchMaxChars = 20000
+	maxFetchRedirects    = 5
+)
+
+// Citation is a source the answer may rely on; it matches citations[] of the qa_v1 schema.
+type Citation struct {
+	URL   string `json:"url"`
+	Title string `json:"title,omitempty"`
+}
+
+// Fetcher downloads web pages for the fetch_url tool and remembers every page it
+// returned, so the answer can cite them.
+type Fetcher struct {
+	// Client is the base of the fetch client: its timeout, and the proxy and TLS
+	// settings of an *http.Transport, are kept, and the check of local addresses
+	// is added to the transport's dialer. Another RoundTripper is used as is and
+	// must do that check itself. nil means a 30s timeout on the default transport.
+	Client *http.Client
+	// Allow and Deny list domains; "example.com" also covers its subdomains.
+	// Deny wins; an empty Allow permits every domain not denied. Loopback,
+	// private and link-local addresses are refused unless their host is listed
+	// in Allow, whatever the domain resolves to and wherever a redirect leads.
+	Allow     []string
+	Deny      []string
+	MaxBytes  int64 // response body limit; longer bodies are cut
+	MaxChars  int   // text returned to the model
+	UserAgent string
+
+	once      sync.Once
+	http      *http.Client
+	mu        sync.Mutex
+	citations []Citation
+}
+
+var defaultFetchClient = &http.Client{Timeout: 30 * time.Second}
+
+// ParseDomains splits a comma- or space-separated list of domains, as in FETCH_ALLOW.
+func ParseDomains(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return r == ',' || r == ' ' || r == ';' })
+}
+
+func matchDomain(host, domain string) bool {
+	domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*."), ".")
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// listed reports whether host is covered by Allow.
+func (f *Fetcher) listed(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range f.Allow {
+		if matchDomain(host, d) {
+			return true
+		}
+	}
+	return false
+}
+
+var sharedAddressSpace = netip.MustParsePrefix("100.64.0.0/10") // carrier-grade NAT
+
+// internalAddr reports whether ip belongs to this machine or a local network.
+func internalAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() || sharedAddressSpace.Contains(ip)
+}
+
+func errInternalAddr(ip netip.Addr) error {
+	return fmt.Errorf("address %s is in a local network; list the host in FETCH_ALLOW to fetch it", ip)
+}
+
+// check reports why u may not be fetched, or nil.
+func (f *Fetcher) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("only http and https URLs can be fetched, got %q", u.Scheme)
+	}
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "" {
+		return fmt.Errorf("URL has no host: %s", u)
+	}
+	for _, d := range f.Deny {
+		if matchDomain(host, d) {
+			return fmt.Errorf("domain %s is blocked", host)
+		}
+	}
+	if f.listed(host) {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(strings.Trim(host, "[]")); err == nil && internalAddr(ip) {
+		return errInternalAddr(ip)
+	}
+	if len(f.Allow) == 0 {
+		return nil
+	}
+	return fmt.Errorf("domain %s is not allowed; allowed: %s", host, strings.Join(f.Allow, ", "))
+}
+
+// client returns a copy of the configured client that applies the domain rules to
+// redirects and refuses connections to local networks. It is made once, so the
+// connections are reused.
+func (f *Fetcher) client() *http.Client {
+	f.once.Do(func() { f.http = f.newClient() })
+	return f.http
+}
+
+func (f *Fetcher) newClient() *http.Client {
+	base := f.Client
+	if base == nil {
+		base = defaultFetchClient
+	}
+	c := *base
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) > maxFetchRedirects {
+			return httpx.Permanent(fmt.Errorf("too many redirects"))
+		}
+		if err := f.check(req.URL); err != nil {
+			return httpx.Permanent(fmt.Errorf("redirect to %s: %w", req.URL, err))
+		}
+		return nil
+	}
+	switch t := c.Transport.(type) {
+	case nil:
+		c.Transport = f.guard(http.DefaultTransport.(*http.Transport))
+	case *http.Transport:
+		c.Transport = f.guard(t)
+	}
+	return &c
+}
+
+// guard returns a copy of t that checks every address it connects to, after DNS
+// resolution, so neither a redirect nor a domain resolving to a local address
+// reaches the local network. Hosts listed in Allow and proxies are exempt.
+func (f *Fetcher) guard(t *http.Transport) *http.Transport {
+	t = t.Clone()
+	proxies := map[string]bool{}
+	if t.Proxy != nil {
+		for _, u := range []string{"http://example.com", "https://example.com"} {
+			req, _ := http.NewRequest(http.MethodGet, u, nil)
+			if p, err := t.Proxy(req); err == nil && p != nil {
+				proxies[p.Hostname()] = true
+			}
+		}
+	}
+	trusted := t.DialContext
+	if trusted == nil {
+		trusted = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	}
+	checked := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			if ap, err := netip.ParseAddrPort(address); err == nil && internalAddr(ap.Addr()) {
+				return httpx.Permanent(errInternalAddr(ap.Addr()))
+			}
+			return nil
+		},
+	}
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, _ := net.SplitHostPort(addr)
+		if f.listed(host) || proxies[host] {
+			return trusted(ctx, network, addr)
+		}
+		return checked.DialContext(ctx, network, addr)
+	}
+	return t
+}
+
+// FetchResult is a downloaded page converted to text.
+type FetchResult struct {
+	URL         string // after redirects
+	Title       string
+	ContentType string
+	Text        string
+	Truncated   bool // the body or the text was cut to the limits
+}
+
+// Fetch downloads rawURL and converts HTML to text. Plain text, JSON and XML are
+// returned as is; other content types are refused.
+func (f *Fetcher) Fetch(ctx context.Context, rawURL string) (*FetchResult, error) {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Scheme == "" && u.Host == "" {
+		// "example.com/page" as models often write it
+		if u, err = url.Parse("https://" + strings.TrimSpace(rawURL)); err != nil {
+			return nil, fmt.Errorf("invalid URL: %w", err)
+		}
+	}
+	if err := f.check(u); err != nil {
+		return nil, err
+	}
+	limit := f.MaxBytes
+	if limit <= 0 {
+		limit = DefaultFetchMaxBytes
+	}
+	res, err := httpx.Do(ctx, f.client(), func() (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "text/html, text/plain;q=0.9, application/json;q=0.8, */*;q=0.5")
+		if f.UserAgent != "" {
+			req.Header.Set("User-Agent", f.UserAgent)
+		}
+		return req, nil
+	})
+	if err != nil {
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, fmt.Errorf("host %s not found", dnsErr.Name)
+		}
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: HTTP %s", u, res.Status)
+	}
+	ctype, params, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	body, err := io.ReadAll(io.LimitReader(res.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", u, err)
+	}
+	out := &FetchResult{URL: res.Request.URL.String(), ContentType: ctype}
+	if int64(len(body)) > limit {
+		body, out.Truncated = body[:limit], true
+	}
+	if ctype == "" {
+		// the sniffed charset is a guess: the document's own declaration is better
+		ctype, _, _ = mime.ParseMediaType(http.DetectContentType(body))
+		params = nil
+	}
+	charset := params["charset"]
+	if ctype == "text/html" || ctype == "application/xhtml+xml" || strings.HasSuffix(ctype, "xml") {
+		// servers often send a default "utf-8" whatever the page declares
+		if declared := sniffCharset(body); charset == "" || declared != "" && !validUTF8(body) {
+			charset = declared
+		}
+	}
+	text, err := decodeText(body, charset)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", u, err)
+	}
+	switch {
+	case ctype == "text/html" || ctype == "application/xhtml+xml":
+		doc := htmltext.Convert(text, res.Request.URL)
+		out.Title, out.Text = doc.Title, doc.Text
+	case strings.HasPrefix(ctype, "text/") || ctype == "application/json" || ctype == "application/xml" ||
+		strings.HasSuffix(ctype, "+json") || strings.HasSuffix(ctype, "+xml"):
+		out.Text = text
+	default:
+		return nil, fmt.Errorf("%s: content type %s is not text", u, ctype)
+	}
+	maxChars := f.MaxChars
+	if maxChars <= 0 {
+		maxChars = DefaultFetchMaxChars
+	}
+	if utf8.RuneCountInString(out.Text) > maxChars {
+		out.Text, out.Truncated = string([]rune(out.Text)[:maxChars]), true
+	}
+	f.cite(Citation{URL: out.URL, Title: out.Title})
+	return out, nil
+}
+
+func (f *Fetcher) cite(c Citation) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, have := range f.citations {
+		if have.URL == c.URL {
+			return
+		}
+	}
+	f.citations = append(f.citations, c)
+}
+
+// TakeCitations returns the pages fetched since the previous call and forgets them.
+func (f *Fetcher) TakeCitations() []Citation {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := f.citations
+	f.citations = nil
+	return c
+}
+
+// RegisterFetchTool registers fetch_url backed by f.
+func RegisterFetchTool(r *Registry, f *Fetcher) {
+	r.MustRegister(Tool{
+		Name:        "fetch_url",
+		Description: "Загружает веб-страницу по URL и возвращает её текст (HTML преобразуется в Markdown). Если ответ опирается на страницу, укажи её URL как источник",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"url": map[string]any{"type": "string", "minLength": 1, "description": "Адрес страницы, http или https"},
+			},
+			"required": []string{"url"},
+		},
+		Handler: FuncContext(func(ctx context.Context, args struct {
+			URL string `json:"url"`
+		}) (string, error) {
+			res, err := f.Fetch(ctx, args.URL)
+			if err != nil {
+				return "", err
+			}
+			var b strings.Builder
+			fmt.Fprintf(&b, "URL: %s\n", res.URL)
+			if res.Title != "" {
+				fmt.Fprintf(&b, "Заголовок: %s\n", res.Title)
+			}
+			b.WriteString("\n")
+			b.WriteString(res.Text)
+			if res.Truncated {
+				b.WriteString("\n\n[текст страницы обрезан]")
+			}
+			return b.String(), nil
+		}),
+		// room for the retries of a slow server
+		Timeout: 2 * time.Minute,
+	})
+}
@@ -0,0 +1,237 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"agent_challenge/internal/httpx"
+	"agent_challenge/internal/openrouter"
+)
+
+// fetchServer serves a few pages for the fetch tests; httptest listens on
+// 127.0.0.1, so the fetchers list it in Allow.
+func fetchServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var secretHits atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte("<html><head><title>Тестовая страница</title></head><body><h1>Заголовок</h1><p>Текст страницы.</p></body></html>"))
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(strings.Repeat("абвгд ", 1000)))
+	})
+	mux.HandleFunc("/cp1251", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><head><meta charset=\"windows-1251\"><title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head><body><p>\xcc\xe8\xf0</p></body></html>"))
+	})
+	mux.HandleFunc("/secret", func(w http.ResponseWriter, r *http.Request) {
+		secretHits.Add(1)
+		w.Write([]byte("secret"))
+	})
+	srv := httptest.NewServer(mux)
+	mux.HandleFunc("/to-localhost", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)+"/secret", http.StatusFound)
+	})
+	mux.HandleFunc("/to-page", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+	})
+	t.Cleanup(srv.Close)
+	return srv, &secretHits
+}
+
+func TestFetchAllowDeny(t *testing.T) {
+	srv, _ := fetchServer(t)
+	byName := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	tests := []struct {
+		name    string
+		f       *Fetcher
+		url     string
+		wantErr string
+	}{
+		{"listed", &Fetcher{Allow: []string{"127.0.0.1"}}, srv.URL, ""},
+		{"listed name", &Fetcher{Allow: []string{"localhost"}}, byName, ""},
+		{"local address without allow", &Fetcher{}, srv.URL, "local network"},
+		{"local name without allow", &Fetcher{}, byName, "local network"},
+		{"denied", &Fetcher{Allow: []string{"127.0.0.1"}, Deny: []string{"127.0.0.1"}}, srv.URL, "blocked"},
+		{"not in allow", &Fetcher{Allow: []string{"example.com"}}, byName, "not allowed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.f.Fetch(context.Background(), tt.url+"/page")
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("Fetch: %v", err)
+				}
+				if !strings.Contains(res.Text, "Текст страницы.") {
+					t.Errorf("text %q lacks the page text", res.Text)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Fetch error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFetchRefusesSchemesAndLocalAddresses(t *testing.T) {
+	f := &Fetcher{}
+	for _, u := range []string{"file:///etc/passwd", "http://169.254.169.254/latest/meta-data/", "http://[::1]/", "http://10.0.0.1/"} {
+		if _, err := f.Fetch(context.Background(), u); err == nil {
+			t.Errorf("Fetch(%s) succeeded", u)
+		}
+	}
+}
+
+func TestFetchRedirect(t *testing.T) {
+	srv, secretHits := fetchServer(t)
+	f := &Fetcher{Allow: []string{"127.0.0.1"}}
+
+	res, err := f.Fetch(context.Background(), srv.URL+"/to-page")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if res.URL != srv.URL+"/page" {
+		t.Errorf("URL = %s, want the redirect target", res.URL)
+	}
+
+	_, err = f.Fetch(context.Background(), srv.URL+"/to-localhost")
+	if err == nil || !strings.Contains(err.Error(), "redirect") {
+		t.Fatalf("redirect to a host outside Allow: error = %v", err)
+	}
+	if n := secretHits.Load(); n != 0 {
+		t.Errorf("redirect target was requested %d times", n)
+	}
+}
+
+func TestFetchTruncation(t *testing.T) {
+	srv, _ := fetchServer(t)
+
+	f := &Fetcher{Allow: []string{"127.0.0.1"}, MaxBytes: 100}
+	res, err := f.Fetch(context.Background(), srv.URL+"/long")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	// 100 bytes end in the middle of a two-byte letter, which is dropped
+	if !res.Truncated || len(res.Text) != 99 || strings.ContainsRune(res.Text, '�') {
+		t.Errorf("MaxBytes: truncated=%v, %d bytes %q", res.Truncated, len(res.Text), res.Text)
+	}
+
+	f = &Fetcher{Allow: []string{"127.0.0.1"}, MaxChars: 10}
+	res, err = f.Fetch(context.Background(), srv.URL+"/long")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if !res.Truncated || res.Text != "абвгд абвг" {
+		t.Errorf("MaxChars: truncated=%v, text %q", res.Truncated, res.Text)
+	}
+}
+
+func TestFetchCharset(t *testing.T) {
+	srv, _ := fetchServer(t)
+	f := &Fetcher{Allow: []string{"127.0.0.1"}}
+	res, err := f.Fetch(context.Background(), srv.URL+"/cp1251")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if res.Title != "Привет" || !strings.Contains(res.Text, "Мир") {
+		t.Errorf("title %q, text %q", res.Title, res.Text)
+	}
+}
+
+func TestFetchCitations(t *testing.T) {
+	srv, _ := fetchServer(t)
+	f := &Fetcher{Allow: []string{"127.0.0.1"}}
+	for _, p := range []string{"/page", "/to-page", "/long"} {
+		if _, err := f.Fetch(context.Background(), srv.URL+p); err != nil {
+			t.Fatalf("Fetch(%s): %v", p, err)
+		}
+	}
+	if _, err := f.Fetch(context.Background(), srv.URL+"/missing"); err == nil {
+		t.Fatal("Fetch of a missing page succeeded")
+	}
+	got := f.TakeCitations()
+	want := []Citation{{URL: srv.URL + "/page", Title: "Тестовая страница"}, {URL: srv.URL + "/long"}}
+	if len(got) != len(want) {
+		t.Fatalf("citations = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("citation %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if again := f.TakeCitations(); len(again) != 0 {
+		t.Errorf("second TakeCitations = %+v, want none", again)
+	}
+}
+
+func TestFetchTool(t *testing.T) {
+	srv, _ := fetchServer(t)
+	r := NewRegistry()
+	RegisterFetchTool(r, &Fetcher{Allow: []string{"127.0.0.1"}})
+	out := r.Execute(context.Background(), openrouter.ToolCall{
+		ID:       "call_1",
+		Type:     "function",
+		Function: openrouter.ToolCallFunction{Name: "fetch_url", Arguments: `{"url":"` + srv.URL + `/page"}`},
+	})
+	for _, want := range []string{"URL: " + srv.URL + "/page", "Заголовок: Тестовая страница", "Текст страницы."} {
+		if !strings.Contains(out, want) {
+			t.Errorf("result lacks %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestFetchClientSettings(t *testing.T) {
+	// a proxy that answers every request itself, like a corporate proxy would
+	var proxied atomic.Int32
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied.Add(1)
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("via proxy: " + r.URL.String()))
+	}))
+	t.Cleanup(proxy.Close)
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	f := &Fetcher{Client: &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}}
+	res, err := f.Fetch(context.Background(), "http://example.test/page")
+	if err != nil {
+		t.Fatalf("Fetch through the proxy: %v", err)
+	}
+	if proxied.Load() != 1 || res.Text != "via proxy: http://example.test/page" {
+		t.Errorf("proxy saw %d requests, text %q", proxied.Load(), res.Text)
+	}
+
+	// the address check stays on a configured transport
+	srv, _ := fetchServer(t)
+	f = &Fetcher{Client: &http.Client{Transport: &http.Transport{}}}
+	byName := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1)
+	if _, err := f.Fetch(context.Background(), byName+"/page"); err == nil || !strings.Contains(err.Error(), "local network") {
+		t.Errorf("Fetch of a local address through a configured transport: error = %v", err)
+	}
+
+	// and so does the timeout of the client
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	t.Cleanup(slow.Close)
+	f = &Fetcher{Allow: []string{"127.0.0.1"}, Client: &http.Client{Timeout: 50 * time.Millisecond}}
+	ctx := httpx.WithRetryPolicy(context.Background(), httpx.NoRetry)
+	start := time.Now()
+	if _, err := f.Fetch(ctx, slow.URL); err == nil {
+		t.Error("Fetch of a slow page succeeded")
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("Fetch took %s despite the client timeout", d)
+	}
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"time"
+
+	"agent_challenge/internal/mcp"
+	"agent_challenge/internal/openrouter"
+)
+
+// MCPToolTimeout limits a call to a tool of an MCP server.
+const MCPToolTimeout = 2 * time.Minute
+
+// mcpSeparator joins the server and tool names of an MCP tool.
+const mcpSeparator = "__"
+
+// maxToolName is the longest tool name the chat APIs accept.
+const maxToolName = 64
+
+// mcpToolName names a server's tool in the registry as server__tool, keeping to
+// the characters and length the chat APIs accept. A name that is too long is cut
+// and ends with a hash of the full one, so tools that differ only past the cut
+// still get different names.
+func mcpToolName(server, tool string) string {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+				return r
+			}
+			return '_'
+		}, s)
+	}
+	name := clean(server) + mcpSeparator + clean(tool)
+	if len(name) > maxToolName {
+		h := fnv.New32a()
+		h.Write([]byte(server + mcpSeparator + tool))
+		suffix := fmt.Sprintf("_%08x", h.Sum32())
+		name = name[:maxToolName-len(suffix)] + suffix
+	}
+	return name
+}
+
+// RegisterMCPTools lists the tools of an MCP server and registers each as
+// server__tool. The calls are forwarded to the server. Tools of other servers
+// are unknown to us, so they run one at a time, like any tool with side effects.
+// It returns the registered names; a tool that cannot be registered, e.g. because
+// its name is taken, is reported in the error and the others are still added.
+func RegisterMCPTools(ctx context.Context, r *Registry, c *mcp.Client) ([]string, error) {
+	list, err := c.ListTools(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	var errs []error
+	for _, t := range list {
+		remote := t.Name
+		schema := t.InputSchema
+		if schema == nil {
+			schema = map[string]any{"type": "object", "properties": map[string]any{}}
+		}
+		desc := t.Description
+		if desc == "" {
+			desc = remote
+		}
+		name := mcpToolName(c.Name(), remote)
+		err := r.Register(Tool{
+			Name:        name,
+			Description: fmt.Sprintf("[MCP %s] %s", c.Name(), desc),
+			Parameters:  schema,
+			Handler: func(ctx context.Context, args json.RawMessage) (string, error) {
+				res, err := c.CallTool(ctx, remote, args)
+				if err != nil {
+					return "", err
+				}
+				if res.IsError {
+					return "", errors.New(res.Text())
+				}
+				return res.Text(), nil
+			},
+			Timeout:    MCPToolTimeout,
+			Sequential: true,
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tool %s: %w", remote, err))
+			continue
+		}
+		names = append(names, name)
+	}
+	return names, errors.Join(errs...)
+}
+
+// NewMCPServer exposes the enabled tools of r to MCP clients. Arguments are
+// validated and calls are time-limited and checked by the policy as in the chat.
+func NewMCPServer(r *Registry, name, version string) *mcp.Server {
+	return &mcp.Server{
+		Name:    name,
+		Version: version,
+		ListTools: func() []mcp.Tool {
+			var tools []mcp.Tool
+			for _, t := range r.Tools() {
+				if r.Enabled(t.Name) {
+					tools = append(tools, mcp.Tool{Name: t.Name, Description: t.Description, InputSchema: t.Parameters})
+				}
+			}
+			return tools
+		},
+		CallTool: func(ctx context.Context, name string, args json.RawMessage) (*mcp.CallResult, error) {
+			if !r.Enabled(name) {
+				return nil, fmt.Errorf("unknown tool %q", name)
+			}
+			var tc openrouter.ToolCall
+			tc.Function.Name = name
+			tc.Function.Arguments = string(args)
+			t, confirm, refusal := r.authorize(tc)
+			text, failed := refusal, true
+			if t != nil {
+				text, failed = r.run(ctx, t, tc, confirm)
+			}
+			return &mcp.CallResult{Content: []mcp.Content{{Type: "text", Text: text}}, IsError: failed}, nil
+		},
+	}
+}
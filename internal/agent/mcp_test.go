@@ -0,0 +1,83 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"agent_challenge/internal/mcp"
+	"agent_challenge/internal/openrouter"
+)
+
+func TestMCPToolName(t *testing.T) {
+	if got := mcpToolName("files", "read.file"); got != "files__read_file" {
+		t.Errorf("mcpToolName = %q", got)
+	}
+	long := strings.Repeat("x", 70)
+	a, b := mcpToolName("srv", long+"_a"), mcpToolName("srv", long+"_b")
+	if len(a) != maxToolName || len(b) != maxToolName {
+		t.Errorf("lengths %d and %d, want %d", len(a), len(b), maxToolName)
+	}
+	if a == b {
+		t.Errorf("tools that differ past the cut share the name %q", a)
+	}
+	if !strings.HasPrefix(a, "srv__xxx") || a != mcpToolName("srv", long+"_a") {
+		t.Errorf("name %q is not stable", a)
+	}
+}
+
+// connectTools serves tools over pipes and connects a client named server to them.
+func connectTools(t *testing.T, server string, tools []mcp.Tool) *mcp.Client {
+	t.Helper()
+	s := &mcp.Server{
+		Name:      "stub",
+		ListTools: func() []mcp.Tool { return tools },
+		CallTool: func(ctx context.Context, name string, args json.RawMessage) (*mcp.CallResult, error) {
+			return &mcp.CallResult{Content: []mcp.Content{{Type: "text", Text: name + " " + string(args)}}}, nil
+		},
+	}
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		s.Serve(context.Background(), serverIn, serverOut)
+		serverOut.Close()
+	}()
+	c, err := mcp.Connect(context.Background(), server, clientOut, clientIn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestRegisterMCPTools(t *testing.T) {
+	long := strings.Repeat("x", 70)
+	c := connectTools(t, "srv", []mcp.Tool{
+		{Name: "echo"},
+		{Name: "taken"},
+		{Name: long + "_a"},
+		{Name: long + "_b"},
+		{Name: "last"},
+	})
+	r := NewRegistry()
+	r.MustRegister(Tool{Name: "srv__taken", Handler: Func(func(struct{}) (string, error) { return "", nil })})
+
+	names, err := RegisterMCPTools(context.Background(), r, c)
+	if err == nil || !strings.Contains(err.Error(), "tool taken") {
+		t.Errorf("error = %v, want the taken name reported", err)
+	}
+	if len(names) != 4 || names[0] != "srv__echo" || names[3] != "srv__last" {
+		t.Fatalf("registered %q", names)
+	}
+
+	out := r.Execute(context.Background(), openrouter.ToolCall{
+		ID:       "call_1",
+		Type:     "function",
+		Function: openrouter.ToolCallFunction{Name: names[2], Arguments: `{"n":1}`},
+	})
+	if out != long+`_b {"n":1}` {
+		t.Errorf("call of %s = %q, want it forwarded to %s_b", names[2], out, long)
+	}
+}
@@ -0,0 +1,256 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PolicyAction says what happens when the model calls a tool.
+type PolicyAction string
+
+const (
+	PolicyAllow PolicyAction = "allow" // run without asking
+	PolicyDeny  PolicyAction = "deny"  // refuse; the model gets an error
+	PolicyAsk   PolicyAction = "ask"   // ask the user before every call
+)
+
+// PolicyConfig is the format of the policy file, e.g.
+//
+//	{"default": "allow", "tools": {"run_command": "ask", "write_*": "ask", "fetch_url": "deny"}, "log": "tool_decisions.jsonl"}
+//
+// Tool names may be path.Match patterns; an exact name wins over patterns, a
+// longer pattern over a shorter one. Tools of MCP servers (server__tool) that no
+// name or pattern matches are asked about, unless the default is deny: unlike
+// the built-in tools they can do anything.
+type PolicyConfig struct {
+	Default PolicyAction            `json:"default"` // for tools not listed; "allow" if empty
+	Tools   map[string]PolicyAction `json:"tools"`
+	Log     string                  `json:"log"` // JSON lines of decisions, relative to the policy file
+}
+
+// LoadPolicyConfig reads and validates a policy file.
+func LoadPolicyConfig(file string) (*PolicyConfig, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var c PolicyConfig
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if c.Default == "" {
+		c.Default = PolicyAllow
+	}
+	if !validAction(c.Default) {
+		return nil, fmt.Errorf("%s: invalid default %q: use allow, deny or ask", file, c.Default)
+	}
+	for name, a := range c.Tools {
+		if !validAction(a) {
+			return nil, fmt.Errorf("%s: invalid action %q for %s: use allow, deny or ask", file, a, name)
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("%s: invalid pattern %q: %w", file, name, err)
+		}
+	}
+	if c.Log == "" {
+		c.Log = "tool_decisions.jsonl"
+	}
+	if !filepath.IsAbs(c.Log) {
+		c.Log = filepath.Join(filepath.Dir(file), c.Log)
+	}
+	return &c, nil
+}
+
+func validAction(a PolicyAction) bool {
+	return a == PolicyAllow || a == PolicyDeny || a == PolicyAsk
+}
+
+// ToolRequest is a call the policy wants the user to confirm.
+type ToolRequest struct {
+	Tool      string
+	Arguments json.RawMessage // validated against the tool's schema
+}
+
+// ToolApproval is the user's decision on a ToolRequest.
+type ToolApproval struct {
+	Approved bool
+	Always   bool   // allow the tool without asking for the rest of the session
+	Reason   string // optional comment on rejection, passed back to the model
+}
+
+// ToolApprover asks the user to confirm tool calls with the "ask" policy.
+// Without an approver such calls are refused.
+type ToolApprover interface {
+	ApproveTool(req ToolRequest) ToolApproval
+}
+
+// PolicyDecision is a line of the decision log.
+type PolicyDecision struct {
+	Time      time.Time       `json:"time"`
+	Tool      string          `json:"tool"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Policy    PolicyAction    `json:"policy"`
+	Allowed   bool            `json:"allowed"`
+	By        string          `json:"by"` // policy, session, user or tool (asks the user itself)
+	Reason    string          `json:"reason,omitempty"`
+}
+
+// Policy decides whether tool calls may run. Install it with Registry.SetPolicy.
+type Policy struct {
+	Config   *PolicyConfig
+	Approver ToolApprover
+
+	mu        sync.Mutex
+	overrides map[string]PolicyAction // set for the session with Set
+	session   map[string]bool         // tools the user allowed with "always"
+}
+
+// Action returns the action for a tool name.
+func (p *Policy) Action(name string) PolicyAction {
+	p.mu.Lock()
+	a, ok := p.overrides[name]
+	p.mu.Unlock()
+	if ok {
+		return a
+	}
+	def := PolicyAllow
+	if p.Config != nil {
+		if a, ok := p.Config.Tools[name]; ok {
+			return a
+		}
+		best := ""
+		for pattern := range p.Config.Tools {
+			if ok, _ := path.Match(pattern, name); ok && (len(pattern) > len(best) || len(pattern) == len(best) && pattern < best) {
+				best = pattern
+			}
+		}
+		if best != "" {
+			return p.Config.Tools[best]
+		}
+		def = p.Config.Default
+	}
+	if def == PolicyAllow && strings.Contains(name, mcpSeparator) {
+		return PolicyAsk
+	}
+	return def
+}
+
+// Set overrides the action for a tool for the rest of the session. It also
+// forgets an earlier "always" answer for the tool.
+func (p *Policy) Set(name string, a PolicyAction) error {
+	if !validAction(a) {
+		return fmt.Errorf("invalid action %q: use allow, deny or ask", a)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.overrides == nil {
+		p.overrides = map[string]PolicyAction{}
+	}
+	p.overrides[name] = a
+	delete(p.session, name)
+	return nil
+}
+
+// SessionAllowed returns the tools allowed with "always" in this session, sorted.
+func (p *Policy) SessionAllowed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.session))
+	for n := range p.session {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// authorize decides on a call and logs the decision. It returns "" if the call
+// may run, or the text sent to the model instead of the result. A tool that
+// confirms its calls itself is not asked about; confirm then reports that the
+// tool must ask the user about this call.
+func (p *Policy) authorize(name string, args json.RawMessage, confirms bool) (refusal string, confirm bool) {
+	d := PolicyDecision{Time: time.Now(), Tool: name, Arguments: args, Policy: p.Action(name), By: "policy"}
+	if len(args) == 0 || !json.Valid(args) {
+		d.Arguments = nil
+	}
+	switch d.Policy {
+	case PolicyAllow:
+		d.Allowed = true
+	case PolicyDeny:
+		refusal = fmt.Sprintf("error: tool %s is denied by the tool policy", name)
+	case PolicyAsk:
+		p.mu.Lock()
+		always := p.session[name]
+		p.mu.Unlock()
+		switch {
+		case always:
+			d.Allowed, d.By = true, "session"
+		case confirms:
+			d.Allowed, d.By, confirm = true, "tool", true
+		case p.Approver == nil:
+			refusal = fmt.Sprintf("error: tool %s needs the user's confirmation, which is not available", name)
+		default:
+			d.By = "user"
+			ap := p.Approver.ApproveTool(ToolRequest{Tool: name, Arguments: args})
+			d.Allowed, d.Reason = ap.Approved, ap.Reason
+			if ap.Approved && ap.Always {
+				p.mu.Lock()
+				if p.session == nil {
+					p.session = map[string]bool{}
+				}
+				p.session[name] = true
+				p.mu.Unlock()
+			}
+			if !ap.Approved {
+				refusal = "пользователь не разрешил вызов " + name
+				if ap.Reason != "" {
+					refusal += ". Комментарий пользователя: " + ap.Reason
+				}
+			}
+		}
+	}
+	p.log(d)
+	return refusal, confirm
+}
+
+type confirmKey struct{}
+
+// withConfirm marks the context of a call whose confirmation the "ask" policy
+// left to the tool.
+func withConfirm(ctx context.Context) context.Context {
+	return context.WithValue(ctx, confirmKey{}, true)
+}
+
+// mustConfirm reports whether the tool must ask the user about the call, even
+// if it would run the call without asking otherwise.
+func mustConfirm(ctx context.Context) bool {
+	confirm, _ := ctx.Value(confirmKey{}).(bool)
+	return confirm
+}
+
+// log appends d to the decision log; a log that cannot be written does not stop the tool.
+func (p *Policy) log(d PolicyDecision) {
+	if p.Config == nil || p.Config.Log == "" {
+		return
+	}
+	b, err := json.Marshal(d)
+	if err != nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	f, err := os.OpenFile(p.Config.Log, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "tool policy: %v\n", err)
+		return
+	}
+	defer f.Close()
+	f.Write(append(b, '\n'))
+}
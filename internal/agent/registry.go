@@ -0,0 +1,325 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// Handler executes a tool call given its raw JSON arguments. It should return
+// promptly once ctx is done.
+type Handler func(ctx context.Context, args json.RawMessage) (string, error)
+
+// Tool timeouts.
+const (
+	DefaultToolTimeout = 30 * time.Second
+	// NoTimeout is for tools that wait for the user or enforce limits of their own.
+	NoTimeout time.Duration = -1
+)
+
+// DefaultToolWorkers is the number of tool calls ExecuteAll runs at once.
+const DefaultToolWorkers = 4
+
+// Tool is a tool the model can call.
+type Tool struct {
+	Name        string
+	Description string
+	Parameters  map[string]any // JSON Schema of the arguments object
+	Handler     Handler
+	Timeout     time.Duration // 0 means DefaultToolTimeout
+	// Sequential tools change state or talk to the user: a call runs alone, after
+	// the calls before it. It is not abandoned on cancellation, only when Timeout
+	// expires, so tools that wait for the user should use NoTimeout.
+	Sequential bool
+	// Confirms marks tools whose handler asks the user itself, like write_file
+	// with its diff. The "ask" policy then leaves the question to the tool
+	// instead of asking twice, and the handler's ctx tells it to ask about
+	// every call (see mustConfirm); "deny" still refuses.
+	Confirms bool
+}
+
+// Func adapts a typed handler: the arguments are decoded into T before fn is called.
+func Func[T any](fn func(args T) (string, error)) Handler {
+	return FuncContext(func(_ context.Context, args T) (string, error) { return fn(args) })
+}
+
+// FuncContext is Func for handlers that observe cancellation.
+func FuncContext[T any](fn func(ctx context.Context, args T) (string, error)) Handler {
+	return func(ctx context.Context, raw json.RawMessage) (string, error) {
+		var args T
+		if len(bytes.TrimSpace(raw)) > 0 {
+			if err := json.Unmarshal(raw, &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+		}
+		return fn(ctx, args)
+	}
+}
+
+// Registry holds the tools of a chat session. Tools can be disabled per session;
+// disabled tools are neither advertised to the model nor executed.
+type Registry struct {
+	mu       sync.RWMutex
+	tools    map[string]*Tool
+	order    []string
+	disabled map[string]bool
+	policy   *Policy
+}
+
+// NewRegistry returns a registry with the built-in tools registered.
+func NewRegistry() *Registry {
+	r := &Registry{tools: map[string]*Tool{}, disabled: map[string]bool{}}
+	registerBuiltins(r)
+	return r
+}
+
+// Register adds a tool. Names must be unique.
+func (r *Registry) Register(t Tool) error {
+	if t.Name == "" || t.Handler == nil {
+		return fmt.Errorf("tool %q: name and handler are required", t.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tools[t.Name]; ok {
+		return fmt.Errorf("tool %q already registered", t.Name)
+	}
+	if t.Parameters == nil {
+		t.Parameters = map[string]any{"type": "object", "properties": map[string]any{}}
+	}
+	r.tools[t.Name] = &t
+	r.order = append(r.order, t.Name)
+	return nil
+}
+
+// MustRegister is Register for tools defined in code; a duplicate name is a programming error.
+func (r *Registry) MustRegister(t Tool) {
+	if err := r.Register(t); err != nil {
+		panic(err)
+	}
+}
+
+// SetEnabled enables or disables a registered tool.
+func (r *Registry) SetEnabled(name string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tools[name]; !ok {
+		return fmt.Errorf("unknown tool %q", name)
+	}
+	if enabled {
+		delete(r.disabled, name)
+	} else {
+		r.disabled[name] = true
+	}
+	return nil
+}
+
+// Enabled reports whether the tool is registered and enabled.
+func (r *Registry) Enabled(name string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	_, ok := r.tools[name]
+	return ok && !r.disabled[name]
+}
+
+// Tools returns all registered tools in registration order.
+func (r *Registry) Tools() []Tool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	out := make([]Tool, 0, len(r.order))
+	for _, name := range r.order {
+		out = append(out, *r.tools[name])
+	}
+	return out
+}
+
+// SetPolicy makes every call pass p before it runs; nil allows all calls.
+func (r *Registry) SetPolicy(p *Policy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.policy = p
+}
+
+// Policy returns the policy set with SetPolicy, or nil.
+func (r *Registry) Policy() *Policy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.policy
+}
+
+// Definitions returns OpenAI-compatible definitions of the enabled tools.
+func (r *Registry) Definitions() []openrouter.Tool {
+	var defs []openrouter.Tool
+	for _, t := range r.Tools() {
+		if !r.Enabled(t.Name) {
+			continue
+		}
+		defs = append(defs, openrouter.Tool{
+			Type:     "function",
+			Function: openrouter.ToolFunction{Name: t.Name, Description: t.Description, Parameters: t.Parameters},
+		})
+	}
+	return defs
+}
+
+// Execute runs a tool call and returns the text sent back to the model.
+// Arguments are validated against the tool's schema first; a mismatch is reported
+// as a structured JSON error. Then the policy, if any, decides whether the call
+// may run. Other failures, timeouts and cancellation are reported as "error: ...".
+func (r *Registry) Execute(ctx context.Context, tc openrouter.ToolCall) string {
+	t, confirm, refusal := r.authorize(tc)
+	if t == nil {
+		return refusal
+	}
+	res, _ := r.run(ctx, t, tc, confirm)
+	return res
+}
+
+// authorize looks up and validates a call and asks the policy about it. It returns
+// the tool if the call may run, or nil and the text sent to the model instead.
+// confirm reports that the tool must ask the user itself.
+func (r *Registry) authorize(tc openrouter.ToolCall) (t *Tool, confirm bool, refusal string) {
+	r.mu.RLock()
+	t, ok := r.tools[tc.Function.Name]
+	disabled := r.disabled[tc.Function.Name]
+	policy := r.policy
+	r.mu.RUnlock()
+	if !ok {
+		return nil, false, "error: unknown tool"
+	}
+	if disabled {
+		return nil, false, "error: tool is disabled in this session"
+	}
+	args := json.RawMessage(tc.Function.Arguments)
+	if aerr := validateArgs(t.Name, t.Parameters, args); aerr != nil {
+		return nil, false, aerr.JSON()
+	}
+	if policy != nil {
+		if refusal, confirm = policy.authorize(t.Name, args, t.Confirms); refusal != "" {
+			return nil, false, refusal
+		}
+	}
+	return t, confirm, ""
+}
+
+// run executes an authorized call with the tool's timeout; confirm comes from
+// authorize. The flag reports that the text is an error message.
+func (r *Registry) run(ctx context.Context, t *Tool, tc openrouter.ToolCall, confirm bool) (string, bool) {
+	if ctx.Err() != nil {
+		return "error: cancelled before the tool started", true
+	}
+	if confirm {
+		ctx = withConfirm(ctx)
+	}
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = DefaultToolTimeout
+	}
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+
+	type result struct {
+		text string
+		err  error
+	}
+	var res result
+	// a handler that ignores ctx is left to finish in the background
+	done := make(chan result, 1)
+	go func() {
+		text, err := t.Handler(ctx, json.RawMessage(tc.Function.Arguments))
+		done <- result{text, err}
+	}()
+	// a sequential handler gets ctx cancelled like the others, but is waited for
+	// until its timeout: it may be in the middle of a change or a question
+	var cancelled <-chan struct{}
+	if !t.Sequential {
+		cancelled = ctx.Done()
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	timedOut := false
+	select {
+	case res = <-done:
+	case <-cancelled:
+		res.err = ctx.Err()
+	case <-expired:
+		timedOut = true
+	}
+	if timedOut {
+		return fmt.Sprintf("error: tool timed out after %s", timeout), true
+	}
+	if res.err != nil {
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			return fmt.Sprintf("error: tool timed out after %s", timeout), true
+		case errors.Is(ctx.Err(), context.Canceled):
+			return "error: cancelled by the user", true
+		}
+		return "error: " + res.err.Error(), true
+	}
+	return res.text, false
+}
+
+// ExecuteAll runs the tool calls of one assistant message and returns their results
+// in call order. The policy is consulted for all calls first, in order, so the user
+// answers its questions one at a time. Then consecutive calls to independent tools
+// run concurrently on up to workers goroutines (DefaultToolWorkers if workers < 1);
+// a call to a Sequential tool waits for the calls before it and runs alone.
+func (r *Registry) ExecuteAll(ctx context.Context, calls []openrouter.ToolCall, workers int) []string {
+	if workers < 1 {
+		workers = DefaultToolWorkers
+	}
+	results := make([]string, len(calls))
+	allowed := make([]*Tool, len(calls))
+	confirm := make([]bool, len(calls))
+	for i, tc := range calls {
+		if ctx.Err() != nil {
+			results[i] = "error: cancelled before the tool started"
+			continue
+		}
+		allowed[i], confirm[i], results[i] = r.authorize(tc)
+	}
+	for i := 0; i < len(calls); {
+		if allowed[i] == nil {
+			i++
+			continue
+		}
+		if allowed[i].Sequential {
+			results[i], _ = r.run(ctx, allowed[i], calls[i], confirm[i])
+			i++
+			continue
+		}
+		j := i + 1
+		for j < len(calls) && (allowed[j] == nil || !allowed[j].Sequential) {
+			j++
+		}
+		sem := make(chan struct{}, workers)
+		var wg sync.WaitGroup
+		for k := i; k < j; k++ {
+			if allowed[k] == nil {
+				continue
+			}
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(k int) {
+				defer func() { <-sem; wg.Done() }()
+				results[k], _ = r.run(ctx, allowed[k], calls[k], confirm[k])
+			}(k)
+		}
+		wg.Wait()
+		i = j
+	}
+	return results
+}
@@ -0,0 +1,195 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ArgumentError reports tool arguments that do not match the tool's JSON Schema.
+// Its JSON form is sent back to the model so it can fix the call and retry.
+type ArgumentError struct {
+	Tool    string
+	Details []string
+	Schema  map[string]any
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("invalid arguments for %s: %s", e.Tool, strings.Join(e.Details, "; "))
+}
+
+// JSON renders the error as the tool result.
+func (e *ArgumentError) JSON() string {
+	b, _ := json.Marshal(map[string]any{
+		"error":   "invalid_arguments",
+		"tool":    e.Tool,
+		"details": e.Details,
+		"schema":  e.Schema,
+		"hint":    "fix the arguments to match the schema and call the tool again",
+	})
+	return string(b)
+}
+
+// validateArgs checks raw call arguments against schema. It supports the subset
+// used by tool definitions: type, properties, required, enum, items,
+// additionalProperties=false, minLength, minimum and maximum.
+func validateArgs(tool string, schema map[string]any, raw json.RawMessage) *ArgumentError {
+	if len(bytes.TrimSpace(raw)) == 0 {
+		raw = json.RawMessage("{}")
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return &ArgumentError{Tool: tool, Details: []string{"arguments: invalid JSON: " + err.Error()}, Schema: schema}
+	}
+	var errs []string
+	validateValue(schema, v, "arguments", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ArgumentError{Tool: tool, Details: errs, Schema: schema}
+}
+
+func validateValue(schema map[string]any, v any, path string, errs *[]string) {
+	if t, ok := schema["type"].(string); ok && !hasType(v, t) {
+		*errs = append(*errs, fmt.Sprintf("%s: expected %s, got %s", path, t, typeName(v)))
+		return
+	}
+	if enum := toSlice(schema["enum"]); enum != nil {
+		found := false
+		for _, e := range enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			*errs = append(*errs, fmt.Sprintf("%s: must be one of %v, got %v", path, enum, v))
+		}
+	}
+	if s, ok := v.(string); ok {
+		if lim, ok := toFloat(schema["minLength"]); ok && float64(len([]rune(strings.TrimSpace(s)))) < lim {
+			*errs = append(*errs, fmt.Sprintf("%s: must contain at least %v non-blank characters", path, lim))
+		}
+	}
+	if n, ok := v.(json.Number); ok {
+		f, _ := n.Float64()
+		if lim, ok := toFloat(schema["minimum"]); ok && f < lim {
+			*errs = append(*errs, fmt.Sprintf("%s: must be >= %v", path, lim))
+		}
+		if lim, ok := toFloat(schema["maximum"]); ok && f > lim {
+			*errs = append(*errs, fmt.Sprintf("%s: must be <= %v", path, lim))
+		}
+	}
+	switch val := v.(type) {
+	case map[string]any:
+		props, _ := schema["properties"].(map[string]any)
+		for _, name := range toSlice(schema["required"]) {
+			if _, ok := val[fmt.Sprint(name)]; !ok {
+				*errs = append(*errs, fmt.Sprintf("%s.%s: required", path, name))
+			}
+		}
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			ps, ok := props[k].(map[string]any)
+			if !ok {
+				if ap, isBool := schema["additionalProperties"].(bool); isBool && !ap {
+					*errs = append(*errs, fmt.Sprintf("%s.%s: unknown property", path, k))
+				}
+				continue
+			}
+			validateValue(ps, val[k], path+"."+k, errs)
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range val {
+				validateValue(items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	}
+}
+
+func hasType(v any, t string) bool {
+	switch t {
+	case "object":
+		_, ok := v.(map[string]any)
+		return ok
+	case "array":
+		_, ok := v.([]any)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "number":
+		_, ok := v.(json.Number)
+		return ok
+	case "integer":
+		n, ok := v.(json.Number)
+		if !ok {
+			return false
+		}
+		_, err := n.Int64()
+		return err == nil
+	case "null":
+		return v == nil
+	}
+	return true
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case map[string]any:
+		return "object"
+	case []any:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case json.Number:
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toSlice accepts both []any (decoded JSON) and typed slices used in Go-defined schemas.
+func toSlice(v any) []any {
+	switch s := v.(type) {
+	case []any:
+		return s
+	case []string:
+		out := make([]any, len(s))
+		for i, x := range s {
+			out[i] = x
+		}
+		return out
+	}
+	return nil
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,417 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of the workspace tools, so a single call cannot flood the context.
+const (
+	maxReadBytes    = 256 << 10 // read_file returns at most this much text
+	defaultReadLine = 400       // read_file lines per call unless limit is given
+	maxListEntries  = 500
+	maxGrepFileSize = 2 << 20 // larger files are skipped by grep
+	maxGrepResults  = 500
+	maxGrepLineLen  = 300
+	sniffLen        = 8000 // bytes checked for binary content
+)
+
+// Workspace confines the file tools to a root directory. Paths are relative to
+// the root; absolute paths, ".." and symlinks that lead outside it are rejected.
+type Workspace struct {
+	root string // absolute, symlinks resolved
+}
+
+// NewWorkspace returns a workspace rooted at dir, which must exist.
+func NewWorkspace(dir string) (*Workspace, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	root, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("workspace %s is not a directory", dir)
+	}
+	return &Workspace{root: root}, nil
+}
+
+// Root returns the absolute workspace directory.
+func (w *Workspace) Root() string { return w.root }
+
+var errOutsideWorkspace = errors.New("path is outside the workspace")
+
+// resolve maps a workspace path to an absolute path inside the root. Symlinks
+// are resolved for the longest existing prefix, so a missing file below a
+// symlinked directory is checked against where the link really points.
+func (w *Workspace) resolve(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		p = "."
+	}
+	var abs string
+	if filepath.IsAbs(p) {
+		abs = filepath.Clean(p)
+	} else {
+		abs = filepath.Join(w.root, p)
+	}
+	if !w.contains(abs) {
+		return "", fmt.Errorf("%s: %w", p, errOutsideWorkspace)
+	}
+	existing, rest := abs, ""
+	for {
+		real, err := filepath.EvalSymlinks(existing)
+		if err == nil {
+			abs = filepath.Join(real, rest)
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		rest = filepath.Join(filepath.Base(existing), rest)
+		existing = parent
+	}
+	if !w.contains(abs) {
+		return "", fmt.Errorf("%s: %w", p, errOutsideWorkspace)
+	}
+	return abs, nil
+}
+
+func (w *Workspace) contains(abs string) bool {
+	rel, err := filepath.Rel(w.root, abs)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rel returns abs relative to the root with forward slashes, as shown to the model.
+func (w *Workspace) rel(abs string) string {
+	rel, err := filepath.Rel(w.root, abs)
+	if err != nil {
+		return abs
+	}
+	return filepath.ToSlash(rel)
+}
+
+// pathError reports a file system error without the absolute path of the root.
+func (w *Workspace) pathError(p string, err error) error {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return fmt.Errorf("%s: no such file or directory", p)
+	case errors.Is(err, fs.ErrPermission):
+		return fmt.Errorf("%s: permission denied", p)
+	}
+	return err
+}
+
+// isBinary reports whether the content looks like a binary file: a NUL byte or
+// invalid UTF-8 in the first sniffLen bytes.
+func isBinary(b []byte) bool {
+	if len(b) > sniffLen {
+		b = b[:sniffLen]
+	}
+	// a multi-byte rune may be cut at the end of the sample
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				b = b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return bytes.IndexByte(b, 0) >= 0 || !utf8.Valid(b)
+}
+
+// skipDir reports directories the tools never descend into.
+func skipDir(name string) bool {
+	return name == ".git" || name == "node_modules"
+}
+
+// ReadFile returns lines [offset, offset+limit) of a text file (1-based offset).
+func (w *Workspace) ReadFile(p string, offset, limit int) (string, error) {
+	abs, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(abs)
+	if err != nil {
+		return "", w.pathError(p, err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	if fi.IsDir() {
+		return "", fmt.Errorf("%s is a directory; use list_dir", p)
+	}
+	head := make([]byte, sniffLen)
+	n, _ := io.ReadFull(f, head)
+	if isBinary(head[:n]) {
+		return "", fmt.Errorf("%s is a binary file (%d bytes)", p, fi.Size())
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if offset < 1 {
+		offset = 1
+	}
+	if limit <= 0 {
+		limit = defaultReadLine
+	}
+	var b strings.Builder
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), maxReadBytes)
+	line, last, truncated := 0, 0, false
+	for sc.Scan() {
+		line++
+		if line < offset {
+			continue
+		}
+		if line >= offset+limit || b.Len()+len(sc.Bytes()) > maxReadBytes {
+			truncated = true
+			break
+		}
+		b.Write(sc.Bytes())
+		b.WriteByte('\n')
+		last = line
+	}
+	if err := sc.Err(); err != nil {
+		return "", fmt.Errorf("%s: %w", p, err)
+	}
+	if line < offset && offset > 1 {
+		return "", fmt.Errorf("%s has only %d lines", p, line)
+	}
+	if truncated {
+		fmt.Fprintf(&b, "[показаны строки %d–%d; продолжение: offset=%d]\n", offset, last, last+1)
+	}
+	return b.String(), nil
+}
+
+// ListDir lists a directory, or its whole tree if recursive. Directories end with "/".
+func (w *Workspace) ListDir(p string, recursive bool) (string, error) {
+	abs, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return "", w.pathError(p, err)
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", p)
+	}
+	var lines []string
+	truncated := false
+	err = filepath.WalkDir(abs, func(fp string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil // unreadable entries are skipped
+		}
+		if fp == abs {
+			return nil
+		}
+		if len(lines) >= maxListEntries {
+			truncated = true
+			return fs.SkipAll
+		}
+		name := w.rel(fp)
+		switch {
+		case d.IsDir():
+			lines = append(lines, name+"/")
+			if !recursive || skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+		case d.Type()&fs.ModeSymlink != 0:
+			lines = append(lines, name+" -> (symlink)")
+		default:
+			size := int64(-1)
+			if info, err := d.Info(); err == nil {
+				size = info.Size()
+			}
+			lines = append(lines, fmt.Sprintf("%s  %d B", name, size))
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "(пусто)", nil
+	}
+	sort.Strings(lines)
+	if truncated {
+		lines = append(lines, fmt.Sprintf("[список обрезан до %d записей]", maxListEntries))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// Grep searches text files under p for a regular expression and returns
+// "path:line: text" matches. glob filters file names, e.g. "*.md".
+func (w *Workspace) Grep(ctx context.Context, pattern, p, glob string, ignoreCase bool, maxResults int) (string, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid pattern: %w", err)
+	}
+	if glob != "" {
+		if _, err := path.Match(glob, ""); err != nil {
+			return "", fmt.Errorf("invalid glob %q: %w", glob, err)
+		}
+	}
+	if maxResults <= 0 || maxResults > maxGrepResults {
+		maxResults = 100
+	}
+	abs, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	var out []string
+	skipped := 0
+	err = filepath.WalkDir(abs, func(fp string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if fp != abs && skipDir(d.Name()) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if glob != "" {
+			if ok, _ := path.Match(glob, d.Name()); !ok {
+				return nil
+			}
+		}
+		if info, err := d.Info(); err != nil || info.Size() > maxGrepFileSize {
+			skipped++
+			return nil
+		}
+		b, err := os.ReadFile(fp)
+		if err != nil || isBinary(b) {
+			return nil
+		}
+		for i, line := range strings.Split(string(b), "\n") {
+			if !re.MatchString(line) {
+				continue
+			}
+			line = strings.TrimRight(line, "\r")
+			if len(line) > maxGrepLineLen {
+				line = strings.ToValidUTF8(line[:maxGrepLineLen], "") + "…"
+			}
+			out = append(out, fmt.Sprintf("%s:%d: %s", w.rel(fp), i+1, line))
+			if len(out) >= maxResults {
+				return fs.SkipAll
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out) == 0 {
+		return "совпадений нет", nil
+	}
+	if len(out) >= maxResults {
+		out = append(out, fmt.Sprintf("[показаны первые %d совпадений]", maxResults))
+	}
+	if skipped > 0 {
+		out = append(out, fmt.Sprintf("[пропущено файлов больше %d МБ: %d]", maxGrepFileSize>>20, skipped))
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// RegisterWorkspaceTools registers read_file, list_dir and grep confined to ws.
+func RegisterWorkspaceTools(r *Registry, ws *Workspace) {
+	pathParam := func(description string) map[string]any {
+		return map[string]any{"type": "string", "description": description + " (относительно корня рабочей папки)"}
+	}
+	r.MustRegister(Tool{
+		Name:        "read_file",
+		Description: "Читает текстовый файл из рабочей папки (например спецификации TZ_*.md). Длинные файлы читаются частями через offset/limit",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path":   pathParam("Путь к файлу"),
+				"offset": map[string]any{"type": "integer", "minimum": 1, "description": "Номер первой строки, с 1"},
+				"limit":  map[string]any{"type": "integer", "minimum": 1, "maximum": 2000, "description": "Сколько строк прочитать (по умолчанию 400)"},
+			},
+			"required": []string{"path"},
+		},
+		Handler: Func(func(args struct {
+			Path   string `json:"path"`
+			Offset int    `json:"offset"`
+			Limit  int    `json:"limit"`
+		}) (string, error) {
+			return ws.ReadFile(args.Path, args.Offset, args.Limit)
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "list_dir",
+		Description: "Показывает содержимое папки в рабочей папке: подпапки с / на конце, файлы с размером",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path":      pathParam("Папка, по умолчанию корень"),
+				"recursive": map[string]any{"type": "boolean", "description": "Включить вложенные папки"},
+			},
+		},
+		Handler: Func(func(args struct {
+			Path      string `json:"path"`
+			Recursive bool   `json:"recursive"`
+		}) (string, error) {
+			return ws.ListDir(args.Path, args.Recursive)
+		}),
+	})
+	r.MustRegister(Tool{
+		Name:        "grep",
+		Description: "Ищет регулярное выражение (синтаксис Go RE2) в текстовых файлах рабочей папки, возвращает строки вида путь:номер: текст",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"pattern":     map[string]any{"type": "string", "minLength": 1, "description": "Регулярное выражение"},
+				"path":        pathParam("Файл или папка для поиска, по умолчанию корень"),
+				"glob":        map[string]any{"type": "string", "description": "Фильтр имён файлов, например *.md"},
+				"ignore_case": map[string]any{"type": "boolean", "description": "Без учёта регистра"},
+				"max_results": map[string]any{"type": "integer", "minimum": 1, "maximum": maxGrepResults, "description": "Максимум совпадений (по умолчанию 100)"},
+			},
+			"required": []string{"pattern"},
+		},
+		Handler: FuncContext(func(ctx context.Context, args struct {
+			Pattern    string `json:"pattern"`
+			Path       string `json:"path"`
+			Glob       string `json:"glob"`
+			IgnoreCase bool   `json:"ignore_case"`
+			MaxResults int    `json:"max_results"`
+		}) (string, error) {
+			return ws.Grep(ctx, args.Pattern, args.Path, args.Glob, args.IgnoreCase, args.MaxResults)
+		}),
+	})
+}
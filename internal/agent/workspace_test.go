@@ -0,0 +1,115 @@
+package agent
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testWorkspace makes a workspace with sub/a.txt and symlinks leading out of it:
+// out -> a directory outside, secret -> a file outside.
+func testWorkspace(t *testing.T) (ws *Workspace, outside string) {
+	t.Helper()
+	root, outside := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for dir, name := range map[string]string{root: "sub/a.txt", outside: "secret.txt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("text\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"out":    outside,
+		"secret": filepath.Join(outside, "secret.txt"),
+		"inner":  filepath.Join(root, "sub"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, link)); err != nil {
+			t.Skipf("symlinks are not available: %v", err)
+		}
+	}
+	ws, err := NewWorkspace(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws, outside
+}
+
+func TestWorkspaceResolve(t *testing.T) {
+	ws, outside := testWorkspace(t)
+	tests := []struct {
+		path string
+		want string // relative to the root; "" means outside the workspace
+	}{
+		{"", "."},
+		{".", "."},
+		{"sub/a.txt", "sub/a.txt"},
+		{"sub/../sub/a.txt", "sub/a.txt"},
+		{"sub/new/file.txt", "sub/new/file.txt"}, // does not exist yet
+		{"inner/a.txt", "sub/a.txt"},             // a symlink that stays inside
+		{"inner/new.txt", "sub/new.txt"},
+		{filepath.Join(ws.Root(), "sub/a.txt"), "sub/a.txt"},
+		{"../x", ""},
+		{"sub/../../x", ""},
+		{"..", ""},
+		{"/etc/passwd", ""},
+		{outside, ""},
+		{"out", ""},
+		{"out/secret.txt", ""},
+		{"secret", ""},
+		{"out/new.txt", ""},      // a new file below a symlinked directory
+		{"out/new/file.txt", ""}, // and deeper
+	}
+	for _, tt := range tests {
+		abs, err := ws.resolve(tt.path)
+		if tt.want == "" {
+			if !errors.Is(err, errOutsideWorkspace) {
+				t.Errorf("resolve(%q) = %q, %v; want outside the workspace", tt.path, abs, err)
+			}
+			continue
+		}
+		if err != nil || ws.rel(abs) != tt.want {
+			t.Errorf("resolve(%q) = %q, %v; want %s", tt.path, abs, err, tt.want)
+		}
+	}
+}
+
+func TestWorkspaceContains(t *testing.T) {
+	ws := &Workspace{root: filepath.FromSlash("/work/root")}
+	tests := []struct {
+		abs  string
+		want bool
+	}{
+		{"/work/root", true},
+		{"/work/root/a", true},
+		{"/work/root/..x", true},
+		{"/work", false},
+		{"/work/root2", false},
+		{"/work/root/../other", false},
+		{"/etc", false},
+	}
+	for _, tt := range tests {
+		if got := ws.contains(filepath.FromSlash(tt.abs)); got != tt.want {
+			t.Errorf("contains(%s) = %v, want %v", tt.abs, got, tt.want)
+		}
+	}
+}
+
+func TestWriteFileThroughSymlink(t *testing.T) {
+	ws, outside := testWorkspace(t)
+	approve := ApproverFunc(func(WriteRequest) Approval { return Approval{Approved: true} })
+	if _, err := ws.WriteFile(approve, "out/new.txt", "x"); !errors.Is(err, errOutsideWorkspace) {
+		t.Errorf("WriteFile through a symlink out of the workspace: error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("file was created outside the workspace: %v", err)
+	}
+	if _, err := ws.WriteFile(approve, "inner/new.txt", "x"); err != nil {
+		t.Fatalf("WriteFile through a symlink inside the workspace: %v", err)
+	}
+	if b, err := os.ReadFile(filepath.Join(ws.Root(), "sub", "new.txt")); err != nil || string(b) != "x" {
+		t.Errorf("sub/new.txt = %q, %v", b, err)
+	}
+}
@@ -0,0 +1,250 @@
+package agent
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxWriteBytes limits files created or rewritten by the write tools.
+const maxWriteBytes = 1 << 20
+
+// WriteRequest is a file change proposed by the model, shown to the user before
+// anything touches disk.
+type WriteRequest struct {
+	Tool    string // write_file or apply_patch
+	Path    string // relative to the workspace root
+	Old     []byte // current content; nil if the file does not exist
+	Content []byte // proposed content
+	Diff    string // unified diff from Old to Content
+}
+
+// Approval is the user's decision on a WriteRequest.
+type Approval struct {
+	Approved bool
+	Content  []byte // if set, the user edited the proposal and this is written instead
+	Reason   string // optional comment on rejection, passed back to the model
+}
+
+// Approver asks the user to confirm file changes. Without an approver the
+// write tools refuse to write.
+type Approver interface {
+	ApproveWrite(req WriteRequest) Approval
+}
+
+// ApproverFunc adapts a function to Approver.
+type ApproverFunc func(req WriteRequest) Approval
+
+func (f ApproverFunc) ApproveWrite(req WriteRequest) Approval { return f(req) }
+
+// current returns the content of an existing text file, or nil if it does not exist.
+func (w *Workspace) current(abs, p string) ([]byte, error) {
+	fi, err := os.Stat(abs)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, w.pathError(p, err)
+	}
+	if fi.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", p)
+	}
+	if fi.Size() > maxWriteBytes {
+		return nil, fmt.Errorf("%s is too large to edit (%d bytes, limit %d)", p, fi.Size(), maxWriteBytes)
+	}
+	b, err := os.ReadFile(abs)
+	if err != nil {
+		return nil, w.pathError(p, err)
+	}
+	if isBinary(b) {
+		return nil, fmt.Errorf("%s is a binary file; only text files can be edited", p)
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// propose shows the change from old to content for approval and writes the approved version.
+func (w *Workspace) propose(approver Approver, tool, p, abs string, old, content []byte) (string, error) {
+	if approver == nil {
+		return "", fmt.Errorf("file writes are disabled in this session")
+	}
+	if len(content) > maxWriteBytes {
+		return "", fmt.Errorf("content is too large (%d bytes, limit %d)", len(content), maxWriteBytes)
+	}
+	rel := w.rel(abs)
+	if old != nil && bytes.Equal(old, content) {
+		return fmt.Sprintf("изменений нет: %s уже содержит этот текст", rel), nil
+	}
+	ap := approver.ApproveWrite(WriteRequest{Tool: tool, Path: rel, Old: old, Content: content, Diff: UnifiedDiff(rel, old, content)})
+	if !ap.Approved {
+		msg := "пользователь отклонил изменение " + rel + ", файл не изменён"
+		if ap.Reason != "" {
+			msg += ". Комментарий пользователя: " + ap.Reason
+		}
+		return msg, nil
+	}
+	final := content
+	if ap.Content != nil {
+		final = ap.Content
+	}
+	if err := writeFileAtomic(abs, final); err != nil {
+		return "", w.pathError(p, err)
+	}
+	added, removed := diffStat(diffLines(splitLines(string(old)), splitLines(string(final))))
+	msg := fmt.Sprintf("записано: %s (+%d −%d строк)", rel, added, removed)
+	if !bytes.Equal(final, content) {
+		msg += "\nпользователь отредактировал предложенный текст перед записью; отличия от предложенного:\n" + UnifiedDiff(rel, content, final)
+	}
+	return msg, nil
+}
+
+// writeFileAtomic replaces path via a temporary file in the same directory, keeping
+// the permissions of an existing file.
+func writeFileAtomic(path string, data []byte) error {
+	mode := fs.FileMode(0o644)
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WriteFile proposes to create or overwrite p with content.
+func (w *Workspace) WriteFile(approver Approver, p, content string) (string, error) {
+	abs, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	old, err := w.current(abs, p)
+	if err != nil {
+		return "", err
+	}
+	return w.propose(approver, "write_file", p, abs, old, []byte(content))
+}
+
+// TextEdit replaces one occurrence of OldText with NewText.
+type TextEdit struct {
+	OldText string `json:"old_text"`
+	NewText string `json:"new_text"`
+}
+
+// ApplyEdits proposes to apply edits to an existing file in order. Each OldText
+// must occur exactly once in the text produced by the previous edits.
+func (w *Workspace) ApplyEdits(approver Approver, p string, edits []TextEdit) (string, error) {
+	abs, err := w.resolve(p)
+	if err != nil {
+		return "", err
+	}
+	old, err := w.current(abs, p)
+	if err != nil {
+		return "", err
+	}
+	if old == nil {
+		return "", fmt.Errorf("%s does not exist; use write_file to create it", p)
+	}
+	text := string(old)
+	// models often send \n where the file has \r\n
+	crlf := strings.Contains(text, "\r\n")
+	for i, e := range edits {
+		from, to := e.OldText, e.NewText
+		if crlf && !strings.Contains(from, "\r\n") {
+			from = strings.ReplaceAll(from, "\n", "\r\n")
+			to = strings.ReplaceAll(to, "\n", "\r\n")
+		}
+		switch n := strings.Count(text, from); {
+		case from == "":
+			return "", fmt.Errorf("edit %d: old_text is empty", i+1)
+		case n == 0:
+			return "", fmt.Errorf("edit %d: old_text not found in %s; read the file again and copy the text exactly", i+1, p)
+		case n > 1:
+			return "", fmt.Errorf("edit %d: old_text occurs %d times in %s; include more surrounding lines to make it unique", i+1, n, p)
+		}
+		text = strings.Replace(text, from, to, 1)
+	}
+	return w.propose(approver, "apply_patch", p, abs, old, []byte(text))
+}
+
+// RegisterWriteTools registers write_file and apply_patch confined to ws. Every
+// change is shown to approver as a diff and written only if approved.
+func RegisterWriteTools(r *Registry, ws *Workspace, approver Approver) {
+	r.MustRegister(Tool{
+		Name:        "write_file",
+		Description: "Создаёт или полностью перезаписывает текстовый файл в рабочей папке. Пользователь увидит diff и подтвердит запись. Для правки части файла используй apply_patch",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path":    map[string]any{"type": "string", "minLength": 1, "description": "Путь к файлу относительно корня рабочей папки"},
+				"content": map[string]any{"type": "string", "description": "Полное новое содержимое файла"},
+			},
+			"required": []string{"path", "content"},
+		},
+		Handler: Func(func(args struct {
+			Path    string `json:"path"`
+			Content string `json:"content"`
+		}) (string, error) {
+			return ws.WriteFile(approver, args.Path, args.Content)
+		}),
+		Timeout:    NoTimeout, // waits for the user's decision
+		Sequential: true,
+		Confirms:   true,
+	})
+	r.MustRegister(Tool{
+		Name: "apply_patch",
+		Description: "Правит существующий текстовый файл в рабочей папке заменами фрагментов, например обновляет один раздел ТЗ. " +
+			"Каждый old_text должен встречаться в файле ровно один раз; прочитай файл через read_file и копируй текст точно. Пользователь увидит diff и подтвердит запись",
+		Parameters: map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"path": map[string]any{"type": "string", "minLength": 1, "description": "Путь к файлу относительно корня рабочей папки"},
+				"edits": map[string]any{
+					"type":        "array",
+					"description": "Замены, применяются по порядку",
+					"items": map[string]any{
+						"type": "object",
+						"properties": map[string]any{
+							"old_text": map[string]any{"type": "string", "minLength": 1, "description": "Точный заменяемый фрагмент"},
+							"new_text": map[string]any{"type": "string", "description": "Новый текст фрагмента"},
+						},
+						"required": []string{"old_text", "new_text"},
+					},
+				},
+			},
+			"required": []string{"path", "edits"},
+		},
+		Handler: Func(func(args struct {
+			Path  string     `json:"path"`
+			Edits []TextEdit `json:"edits"`
+		}) (string, error) {
+			if len(args.Edits) == 0 {
+				return "", fmt.Errorf("edits is empty")
+			}
+			return ws.ApplyEdits(approver, args.Path, args.Edits)
+		}),
+		Timeout:    NoTimeout,
+		Sequential: true,
+		Confirms:   true,
+	})
+}
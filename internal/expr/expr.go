@@ -0,0 +1,216 @@
+// Package expr parses arithmetic expressions into an AST.
+//
+// The grammar, from lowest to highest precedence:
+//
+//	statements = [statement] { ";" [statement] }
+//	statement  = [ident "="] expr
+//	expr       = term { ("+" | "-") term }
+//	term       = unary { ("*" | "/" | "%") unary | implicit }
+//	unary      = ("-" | "+") unary | power
+//	power      = primary ["^" unary]            (right-associative)
+//	primary    = number | ident | ident "(" [expr { "," expr }] ")" | "(" expr ")"
+//
+// Implicit multiplication applies when a "(" or an identifier directly follows an
+// operand: 2(3), (1+2)(3), 2pi and 2 x are products with the precedence of "*".
+// An identifier followed by "(" is always a function call, and two numbers in a
+// row (3 4) are an error. Evaluation is left to the caller.
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Node is an expression tree node.
+type Node interface {
+	// Pos returns the byte offset in the source that errors about the node point at.
+	Pos() int
+}
+
+// Number is a numeric literal as written, e.g. "2.5e-3".
+type Number struct {
+	At   int
+	Text string
+}
+
+// Ident is a constant or variable reference.
+type Ident struct {
+	At   int
+	Name string
+}
+
+// Unary is a prefix operator ('-' or '+') applied to X.
+type Unary struct {
+	At int
+	Op byte
+	X  Node
+}
+
+// Binary is X Op Y. At is the operator position; for implicit multiplication
+// (Implicit set) it is the start of Y.
+type Binary struct {
+	At       int
+	Op       byte
+	X, Y     Node
+	Implicit bool
+}
+
+// Call is a function call Name(Args...).
+type Call struct {
+	At   int
+	Name string
+	Args []Node
+}
+
+func (n *Number) Pos() int { return n.At }
+func (n *Ident) Pos() int  { return n.At }
+func (n *Unary) Pos() int  { return n.At }
+func (n *Binary) Pos() int { return n.At }
+func (n *Call) Pos() int   { return n.At }
+
+func (n *Number) String() string { return Format(n) }
+func (n *Ident) String() string  { return Format(n) }
+func (n *Unary) String() string  { return Format(n) }
+func (n *Binary) String() string { return Format(n) }
+func (n *Call) String() string   { return Format(n) }
+
+// Statement is "name = value" or a bare expression (Name is empty).
+type Statement struct {
+	Name    string
+	NamePos int
+	Value   Node
+}
+
+// Error is a syntax or evaluation error at a position in the source. Its message
+// shows the column and the offending line with a caret under it:
+//
+//	unexpected ")" at column 5
+//	  2 + )
+//	      ^
+type Error struct {
+	Src string
+	Pos int // byte offset in Src
+	Msg string
+}
+
+// Errorf returns an *Error at pos in src.
+func Errorf(src string, pos int, format string, args ...any) *Error {
+	return &Error{Src: src, Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Line and Column return the 1-based location of the error; columns count runes.
+func (e *Error) Line() int {
+	return strings.Count(e.Src[:e.clampPos()], "\n") + 1
+}
+
+func (e *Error) Column() int {
+	pos := e.clampPos()
+	start := strings.LastIndexByte(e.Src[:pos], '\n') + 1
+	return utf8.RuneCountInString(e.Src[start:pos]) + 1
+}
+
+func (e *Error) clampPos() int {
+	return max(0, min(e.Pos, len(e.Src)))
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Msg)
+	if strings.Contains(e.Src, "\n") {
+		fmt.Fprintf(&b, " at line %d, column %d", e.Line(), e.Column())
+	} else {
+		fmt.Fprintf(&b, " at column %d", e.Column())
+	}
+	pos := e.clampPos()
+	start := strings.LastIndexByte(e.Src[:pos], '\n') + 1
+	end := len(e.Src)
+	if i := strings.IndexByte(e.Src[pos:], '\n'); i >= 0 {
+		end = pos + i
+	}
+	// keep tabs in the caret line so it stays aligned with the source line
+	pad := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.Src[start:pos])
+	fmt.Fprintf(&b, "\n  %s\n  %s^", e.Src[start:end], pad)
+	return b.String()
+}
+
+// Format prints n with single spaces around binary operators and only the
+// parentheses the precedence requires. Implicit multiplication is written out
+// as "*", so the result shows how the input was understood: Format of 2(3+x)
+// is "2 * (3 + x)".
+func Format(n Node) string {
+	var b strings.Builder
+	format(&b, n, 0)
+	return b.String()
+}
+
+const (
+	precAdd = iota + 1
+	precMul
+	precUnary
+	precPow
+	precAtom
+)
+
+func precedence(n Node) int {
+	switch n := n.(type) {
+	case *Binary:
+		switch n.Op {
+		case '+', '-':
+			return precAdd
+		case '^':
+			return precPow
+		default:
+			return precMul
+		}
+	case *Unary:
+		return precUnary
+	default:
+		return precAtom
+	}
+}
+
+func format(b *strings.Builder, n Node, min int) {
+	p := precedence(n)
+	if p < min {
+		b.WriteByte('(')
+		defer b.WriteByte(')')
+	}
+	switch n := n.(type) {
+	case *Number:
+		b.WriteString(n.Text)
+	case *Ident:
+		b.WriteString(n.Name)
+	case *Unary:
+		b.WriteByte(n.Op)
+		format(b, n.X, precUnary)
+	case *Binary:
+		if n.Op == '^' {
+			// right-associative; the exponent may be a signed operand: 2^-3
+			format(b, n.X, precAtom)
+			b.WriteByte('^')
+			format(b, n.Y, precUnary)
+			return
+		}
+		format(b, n.X, p)
+		b.WriteByte(' ')
+		b.WriteByte(n.Op)
+		b.WriteByte(' ')
+		format(b, n.Y, p+1)
+	case *Call:
+		b.WriteString(n.Name)
+		b.WriteByte('(')
+		for i, a := range n.Args {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			format(b, a, 0)
+		}
+		b.WriteByte(')')
+	}
+}
@@ -0,0 +1,194 @@
+package expr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tree prints n fully parenthesized in prefix form; implicit products are "*i".
+func tree(n Node) string {
+	switch n := n.(type) {
+	case *Number:
+		return n.Text
+	case *Ident:
+		return n.Name
+	case *Unary:
+		return "(" + string(n.Op) + " " + tree(n.X) + ")"
+	case *Binary:
+		op := string(n.Op)
+		if n.Implicit {
+			op += "i"
+		}
+		return "(" + op + " " + tree(n.X) + " " + tree(n.Y) + ")"
+	case *Call:
+		s := "(" + n.Name
+		for _, a := range n.Args {
+			s += " " + tree(a)
+		}
+		return s + ")"
+	}
+	return "?"
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		// precedence and associativity
+		{"1+2*3", "(+ 1 (* 2 3))"},
+		{"1*2+3", "(+ (* 1 2) 3)"},
+		{"1-2-3", "(- (- 1 2) 3)"},
+		{"8/4/2", "(/ (/ 8 4) 2)"},
+		{"2*3%4", "(% (* 2 3) 4)"},
+		{"(1+2)*3", "(* (+ 1 2) 3)"},
+		{"2^3^2", "(^ 2 (^ 3 2))"},
+		{"2*3^2", "(* 2 (^ 3 2))"},
+		{"(2^3)^2", "(^ (^ 2 3) 2)"},
+		// unary minus binds looser than ^ and tighter than *
+		{"-2^2", "(- (^ 2 2))"},
+		{"2^-2", "(^ 2 (- 2))"},
+		{"2^-x^2", "(^ 2 (- (^ x 2)))"},
+		{"-x*y", "(* (- x) y)"},
+		{"--1", "(- (- 1))"},
+		{"+1", "(+ 1)"},
+		// numbers and calls
+		{"1e3+2.5E-4", "(+ 1e3 2.5E-4)"},
+		{".5", ".5"},
+		{"f()", "(f)"},
+		{"max(1, 2+3, x)", "(max 1 (+ 2 3) x)"},
+		// implicit multiplication
+		{"2x", "(*i 2 x)"},
+		{"2 x", "(*i 2 x)"},
+		{"2(3)", "(*i 2 3)"},
+		{"(1)(2)", "(*i 1 2)"},
+		{"(1+2)(3-4)", "(*i (+ 1 2) (- 3 4))"},
+		{"x y", "(*i x y)"},
+		{"2e", "(*i 2 e)"},
+		{"2pi^2", "(*i 2 (^ pi 2))"},
+		{"2x+1", "(+ (*i 2 x) 1)"},
+		{"1/2x", "(*i (/ 1 2) x)"},
+		{"-2x", "(*i (- 2) x)"},
+		{"2sin(x)", "(*i 2 (sin x))"},
+		{"x(2)", "(x 2)"}, // a call, not a product
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.src)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.src, err)
+			continue
+		}
+		if got := tree(n); got != tt.want {
+			t.Errorf("Parse(%q) = %s, want %s", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct{ src, want string }{
+		{"2(3+x)", "2 * (3 + x)"},
+		{"1-(2-3)", "1 - (2 - 3)"},
+		{"(1-2)-3", "1 - 2 - 3"},
+		{"2^-3", "2^-3"},
+		{"(2^3)^2", "(2^3)^2"},
+		{"-2^2", "-2^2"},
+		{"(-2)^2", "(-2)^2"},
+		{"f(1,2)", "f(1, 2)"},
+	}
+	for _, tt := range tests {
+		n, err := Parse(tt.src)
+		if err != nil {
+			t.Fatalf("Parse(%q): %v", tt.src, err)
+		}
+		if got := Format(n); got != tt.want {
+			t.Errorf("Format(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		src    string
+		msg    string
+		column int
+	}{
+		{"3 4", "missing operator before \"4\"", 3},
+		{"f(1 2)", "missing operator before \"2\"", 5},
+		{"2 + )", "unexpected \")\"", 5},
+		{"1 +", "unexpected end of expression", 4},
+		{"(1+2", "unclosed \"(\"", 1},
+		{"f(1, 2", "unclosed \"(\" of f", 2},
+		{"1..2", "malformed number", 1},
+		{"* 2", "missing operand before \"*\"", 1},
+		{"1 2", "missing operator", 3},
+		{"1)", "unexpected \")\"", 2},
+		{"2 + α", "invalid character 'α'", 5},
+		{"«2» + 1", "invalid character '«'", 1},
+	}
+	for _, tt := range tests {
+		_, err := Parse(tt.src)
+		var e *Error
+		if !errors.As(err, &e) {
+			t.Errorf("Parse(%q) error = %v, want *Error", tt.src, err)
+			continue
+		}
+		if !strings.HasPrefix(e.Msg, tt.msg) || e.Column() != tt.column {
+			t.Errorf("Parse(%q) = %q at column %d, want %q at column %d", tt.src, e.Msg, e.Column(), tt.msg, tt.column)
+		}
+	}
+}
+
+func TestErrorCaret(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{
+			Errorf("2 + )", 4, "unexpected \")\""),
+			"unexpected \")\" at column 5\n  2 + )\n      ^",
+		},
+		{
+			// columns and the caret count runes, not bytes
+			Errorf("площадь = )", len("площадь = "), "unexpected \")\""),
+			"unexpected \")\" at column 11\n  площадь = )\n            ^",
+		},
+		{
+			Errorf("x\t+ )", 4, "unexpected \")\""),
+			"unexpected \")\" at column 5\n  x\t+ )\n   \t  ^",
+		},
+		{
+			Errorf("a = 1\nb = ü +", len("a = 1\nb = ü +"), "unexpected end of expression"),
+			"unexpected end of expression at line 2, column 8\n  b = ü +\n         ^",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() =\n%s\nwant\n%s", got, tt.want)
+		}
+	}
+}
+
+func TestParseStatementsPositions(t *testing.T) {
+	stmts, err := ParseStatements("x = 2; 3x")
+	if err != nil {
+		t.Fatalf("ParseStatements: %v", err)
+	}
+	if len(stmts) != 2 || stmts[0].Name != "x" || stmts[1].Name != "" || tree(stmts[1].Value) != "(*i 3 x)" {
+		t.Fatalf("statements = %+v", stmts)
+	}
+	if p := stmts[1].Value.(*Binary).Y.Pos(); p != 8 {
+		t.Errorf("position of x in the second statement = %d, want 8", p)
+	}
+
+	_, err = ParseStatements("a = 1;\nb = 2 +")
+	var e *Error
+	if !errors.As(err, &e) || e.Line() != 2 || e.Column() != 8 {
+		t.Errorf("error = %v, want line 2, column 8", err)
+	}
+	for _, src := range []string{"x =", "1 = 2", "x = 1 2"} {
+		if _, err := ParseStatements(src); err == nil {
+			t.Errorf("ParseStatements(%q) succeeded", src)
+		}
+	}
+}
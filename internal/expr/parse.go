@@ -0,0 +1,323 @@
+package expr
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokNumber
+	tokIdent
+	tokOp // + - * / % ^
+	tokLparen
+	tokRparen
+	tokComma
+	tokSemi
+	tokAssign
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func lex(src string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.IndexByte("+-*/%^", c) >= 0:
+			toks = append(toks, token{tokOp, src[i : i+1], i})
+			i++
+		case c == '(':
+			toks = append(toks, token{tokLparen, "(", i})
+			i++
+		case c == ')':
+			toks = append(toks, token{tokRparen, ")", i})
+			i++
+		case c == ',':
+			toks = append(toks, token{tokComma, ",", i})
+			i++
+		case c == ';':
+			toks = append(toks, token{tokSemi, ";", i})
+			i++
+		case c == '=':
+			toks = append(toks, token{tokAssign, "=", i})
+			i++
+		case isDigit(c) || c == '.':
+			j, dots := i, 0
+			for j < len(src) && (isDigit(src[j]) || src[j] == '.') {
+				if src[j] == '.' {
+					dots++
+				}
+				j++
+			}
+			if dots > 1 || j == i+dots {
+				return nil, Errorf(src, i, "malformed number %q", src[i:j])
+			}
+			// scientific notation: 1e3, 2.5E-4; a bare "2e" is 2 times e
+			if j < len(src) && (src[j] == 'e' || src[j] == 'E') {
+				k := j + 1
+				if k < len(src) && (src[k] == '+' || src[k] == '-') {
+					k++
+				}
+				if k < len(src) && isDigit(src[k]) {
+					for k < len(src) && isDigit(src[k]) {
+						k++
+					}
+					j = k
+				}
+			}
+			toks = append(toks, token{tokNumber, src[i:j], i})
+			i = j
+		case isIdentStart(c):
+			j := i + 1
+			for j < len(src) && (isIdentStart(src[j]) || isDigit(src[j])) {
+				j++
+			}
+			toks = append(toks, token{tokIdent, src[i:j], i})
+			i = j
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return nil, Errorf(src, i, "invalid character %q", r)
+		}
+	}
+	return append(toks, token{tokEOF, "", len(src)}), nil
+}
+
+type parser struct {
+	src  string
+	toks []token
+	i    int
+}
+
+func newParser(src string) (*parser, error) {
+	toks, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	return &parser{src: src, toks: toks}, nil
+}
+
+func (p *parser) peek() token { return p.toks[p.i] }
+
+func (p *parser) next() token {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+func (p *parser) errorf(pos int, format string, args ...any) error {
+	return Errorf(p.src, pos, format, args...)
+}
+
+// Parse parses a single expression.
+func Parse(src string) (Node, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+	n, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+	return n, nil
+}
+
+// ParseStatements parses ';'-separated statements, each an expression or an
+// assignment "name = expr". Empty statements are skipped. Positions in the
+// result and in errors are offsets into the whole src.
+func ParseStatements(src string) ([]Statement, error) {
+	p, err := newParser(src)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []Statement
+	for {
+		t := p.peek()
+		if t.kind == tokEOF {
+			return stmts, nil
+		}
+		if t.kind == tokSemi {
+			p.next()
+			continue
+		}
+		var st Statement
+		if t.kind == tokIdent && p.toks[p.i+1].kind == tokAssign {
+			p.next()
+			p.next()
+			st.Name, st.NamePos = t.text, t.pos
+			if k := p.peek().kind; k == tokSemi || k == tokEOF {
+				return nil, p.errorf(p.peek().pos, "missing value for %s", t.text)
+			}
+		}
+		if st.Value, err = p.expr(); err != nil {
+			return nil, err
+		}
+		switch t := p.peek(); t.kind {
+		case tokSemi, tokEOF:
+		case tokAssign:
+			return nil, p.errorf(t.pos, "left side of \"=\" must be a variable name")
+		default:
+			return nil, p.errorf(t.pos, "unexpected %s", t)
+		}
+		stmts = append(stmts, st)
+	}
+}
+
+func (p *parser) expr() (Node, error) {
+	x, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		if t.kind != tokOp || (t.text != "+" && t.text != "-") {
+			return x, nil
+		}
+		p.next()
+		y, err := p.term()
+		if err != nil {
+			return nil, err
+		}
+		x = &Binary{At: t.pos, Op: t.text[0], X: x, Y: y}
+	}
+}
+
+func (p *parser) term() (Node, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokOp && strings.Contains("*/%", t.text):
+			p.next()
+			y, err := p.unary()
+			if err != nil {
+				return nil, err
+			}
+			x = &Binary{At: t.pos, Op: t.text[0], X: x, Y: y}
+		case t.kind == tokLparen || t.kind == tokIdent:
+			// implicit multiplication: 2(3), 2pi
+			y, err := p.power()
+			if err != nil {
+				return nil, err
+			}
+			x = &Binary{At: t.pos, Op: '*', X: x, Y: y, Implicit: true}
+		case t.kind == tokNumber:
+			return nil, p.errorf(t.pos, "missing operator before %s", t)
+		default:
+			return x, nil
+		}
+	}
+}
+
+func (p *parser) unary() (Node, error) {
+	t := p.peek()
+	if t.kind == tokOp && (t.text == "-" || t.text == "+") {
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Unary{At: t.pos, Op: t.text[0], X: x}, nil
+	}
+	return p.power()
+}
+
+func (p *parser) power() (Node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && t.text == "^" {
+		p.next()
+		y, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &Binary{At: t.pos, Op: '^', X: x, Y: y}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) primary() (Node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return &Number{At: t.pos, Text: t.text}, nil
+	case tokIdent:
+		if p.peek().kind != tokLparen {
+			return &Ident{At: t.pos, Name: t.text}, nil
+		}
+		open := p.next()
+		call := &Call{At: t.pos, Name: t.text}
+		if p.peek().kind == tokRparen {
+			p.next()
+			return call, nil
+		}
+		for {
+			a, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			call.Args = append(call.Args, a)
+			switch c := p.next(); c.kind {
+			case tokComma:
+				continue
+			case tokRparen:
+				return call, nil
+			case tokEOF:
+				return nil, p.errorf(open.pos, "unclosed \"(\" of %s: expected \")\"", t.text)
+			default:
+				return nil, p.errorf(c.pos, "expected \",\" or \")\" in arguments of %s, got %s", t.text, c)
+			}
+		}
+	case tokLparen:
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		switch c := p.next(); c.kind {
+		case tokRparen:
+			return x, nil
+		case tokEOF:
+			return nil, p.errorf(t.pos, "unclosed \"(\": expected \")\"")
+		default:
+			return nil, p.errorf(c.pos, "expected \")\", got %s", c)
+		}
+	case tokEOF:
+		return nil, p.errorf(t.pos, "unexpected end of expression: operand expected")
+	case tokOp:
+		return nil, p.errorf(t.pos, "missing operand before %s", t)
+	default:
+		return nil, p.errorf(t.pos, "unexpected %s", t)
+	}
+}
@@ -0,0 +1,543 @@
+// Package htmltext converts HTML pages into readable text for language models.
+//
+// Scripts, styles and other non-content elements are dropped and whitespace is
+// collapsed. Structure that helps reading is kept in a light Markdown form:
+//
+//	<h2>Title</h2>            ## Title
+//	<li>item</li>             - item   (1. item inside <ol>)
+//	<a href="/x">text</a>     [text](https://host/x)
+//	<pre>code</pre>           fenced ``` block, whitespace preserved
+//	<code>x</code>            `x`
+//	<blockquote>q</blockquote> > q
+//	<td>a</td><td>b</td>      a | b
+//
+// The parser is deliberately forgiving: unknown and unbalanced tags are
+// ignored rather than reported, as browsers do.
+package htmltext
+
+import (
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Document is the result of Convert.
+type Document struct {
+	Title string // content of <title>, whitespace collapsed
+	Text  string
+}
+
+// Convert extracts the text of an HTML document. Relative links are resolved
+// against base, which may be nil.
+func Convert(src string, base *url.URL) Document {
+	c := &converter{base: base}
+	tokenize(src, c.token)
+	return Document{
+		Title: strings.Join(strings.Fields(c.title.String()), " "),
+		Text:  strings.TrimSpace(string(c.out)),
+	}
+}
+
+// skipped elements contribute no text at all.
+var skipped = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "svg": true,
+	"math": true, "iframe": true, "object": true, "canvas": true, "select": true,
+}
+
+// void elements have no end tag.
+var void = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true, "img": true,
+	"input": true, "link": true, "meta": true, "param": true, "source": true, "track": true, "wbr": true,
+}
+
+// paragraphs are separated by a blank line, lines by a single line break.
+var paragraphs = map[string]bool{
+	"p": true, "blockquote": true, "table": true, "ul": true, "ol": true, "dl": true,
+	"figure": true, "form": true, "fieldset": true, "address": true, "details": true,
+}
+
+var lines = map[string]bool{
+	"div": true, "section": true, "article": true, "header": true, "footer": true, "main": true,
+	"nav": true, "aside": true, "tr": true, "dt": true, "dd": true, "caption": true,
+	"figcaption": true, "summary": true, "center": true, "hgroup": true,
+}
+
+type list struct {
+	ordered bool
+	n       int
+}
+
+type link struct {
+	href  string
+	start int // offset in out right after "["
+}
+
+type converter struct {
+	base  *url.URL
+	out   []byte
+	title strings.Builder
+
+	space   bool   // whitespace seen since the last word
+	skip    string // element being skipped
+	depth   int    // nesting of skip inside itself
+	inTitle bool
+	pre     int
+	preLead bool // at the start of a <pre>, where a leading newline is ignored
+	quote   int
+	lists   []list
+	links   []link
+	cells   int // cells written in the current table row
+}
+
+func (c *converter) token(t token) {
+	if c.skip != "" {
+		switch {
+		case t.kind == startTag && t.name == c.skip && !t.selfClosing:
+			c.depth++
+		case t.kind == endTag && t.name == c.skip:
+			if c.depth--; c.depth < 0 {
+				c.skip = ""
+			}
+		}
+		return
+	}
+	switch t.kind {
+	case textToken:
+		c.text(t.text)
+	case startTag:
+		if skipped[t.name] {
+			if !t.selfClosing {
+				c.skip, c.depth = t.name, 0
+			}
+			return
+		}
+		c.start(t)
+	case endTag:
+		c.end(t.name)
+	}
+}
+
+func (c *converter) text(s string) {
+	switch {
+	case c.inTitle:
+		c.title.WriteString(s)
+	case c.pre > 0:
+		c.writePre(s)
+	default:
+		words := strings.Fields(s)
+		if len(words) == 0 {
+			if s != "" {
+				c.space = true
+			}
+			return
+		}
+		if isSpace(s[0]) {
+			c.space = true
+		}
+		for i, w := range words {
+			if i > 0 {
+				c.space = true
+			}
+			c.write(w)
+		}
+		if isSpace(s[len(s)-1]) {
+			c.space = true
+		}
+	}
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func (c *converter) start(t token) {
+	name := t.name
+	switch {
+	case name == "title":
+		c.inTitle = true
+	case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+		c.block(2)
+		c.write(strings.Repeat("#", int(name[1]-'0')))
+		c.space = true
+	case name == "br":
+		c.out = append(c.out, '\n')
+		c.space = false
+	case name == "hr":
+		c.block(2)
+		c.write("---")
+		c.block(2)
+	case name == "pre":
+		c.block(2)
+		c.write("```")
+		c.out = append(c.out, '\n')
+		c.pre++
+		c.preLead = true
+	case name == "code" && c.pre == 0:
+		c.write("`")
+		c.space = false
+	case name == "blockquote":
+		c.block(2)
+		c.quote++
+	case name == "ul" || name == "ol":
+		if len(c.lists) == 0 {
+			c.block(2)
+		} else {
+			c.block(1)
+		}
+		c.lists = append(c.lists, list{ordered: name == "ol"})
+	case name == "li":
+		c.block(1)
+		if len(c.lists) == 0 {
+			c.write("-")
+			c.space = true
+			return
+		}
+		l := &c.lists[len(c.lists)-1]
+		l.n++
+		c.write(strings.Repeat("  ", len(c.lists)-1))
+		if l.ordered {
+			c.out = strconv.AppendInt(c.out, int64(l.n), 10)
+			c.out = append(c.out, '.')
+		} else {
+			c.out = append(c.out, '-')
+		}
+		c.space = true
+	case name == "tr":
+		c.block(1)
+		c.cells = 0
+	case name == "td" || name == "th":
+		if c.cells > 0 {
+			c.space = true
+			c.write("|")
+			c.space = true
+		}
+		c.cells++
+	case name == "a" && c.pre == 0:
+		href := c.resolve(t.attrs["href"])
+		if href == "" {
+			return
+		}
+		c.write("[")
+		c.space = false
+		c.links = append(c.links, link{href: href, start: len(c.out)})
+	case name == "img":
+		if alt := strings.TrimSpace(t.attrs["alt"]); alt != "" && len(c.links) > 0 {
+			// image links would otherwise have no text at all
+			c.text(alt)
+		}
+	case paragraphs[name]:
+		c.block(2)
+	case lines[name]:
+		c.block(1)
+	}
+}
+
+func (c *converter) end(name string) {
+	switch {
+	case name == "title":
+		c.inTitle = false
+	case len(name) == 2 && name[0] == 'h' && name[1] >= '1' && name[1] <= '6':
+		c.block(2)
+	case name == "pre":
+		if c.pre == 0 {
+			return
+		}
+		c.pre--
+		if len(c.out) > 0 && c.out[len(c.out)-1] != '\n' {
+			c.out = append(c.out, '\n')
+		}
+		c.write("```")
+		c.block(2)
+	case name == "code" && c.pre == 0:
+		c.space = false
+		c.write("`")
+	case name == "blockquote":
+		if c.quote > 0 {
+			c.quote--
+		}
+		c.block(2)
+	case name == "li":
+		c.block(1)
+	case name == "ul" || name == "ol":
+		if len(c.lists) > 0 {
+			c.lists = c.lists[:len(c.lists)-1]
+		}
+		if len(c.lists) == 0 {
+			c.block(2)
+		}
+	case name == "a":
+		if len(c.links) == 0 {
+			return
+		}
+		l := c.links[len(c.links)-1]
+		c.links = c.links[:len(c.links)-1]
+		if len(c.out) == l.start {
+			// nothing to show: drop the "["
+			c.out = c.out[:l.start-1]
+			return
+		}
+		c.space = false
+		c.write("](" + l.href + ")")
+	case paragraphs[name]:
+		c.block(2)
+	case lines[name]:
+		c.block(1)
+	}
+}
+
+// resolve returns an absolute link target, or "" for links not worth showing.
+func (c *converter) resolve(href string) string {
+	href = strings.TrimSpace(href)
+	if href == "" || strings.HasPrefix(href, "#") {
+		return ""
+	}
+	u, err := url.Parse(href)
+	if err != nil {
+		return ""
+	}
+	if c.base != nil {
+		u = c.base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto", "":
+		return u.String()
+	}
+	return "" // javascript:, data: and the like
+}
+
+// atLineStart reports whether the next write begins a new line.
+func (c *converter) atLineStart() bool {
+	return len(c.out) == 0 || c.out[len(c.out)-1] == '\n'
+}
+
+// write appends an inline fragment, preceded by a space if whitespace was seen.
+func (c *converter) write(s string) {
+	if c.atLineStart() {
+		c.prefix()
+	} else if c.space {
+		c.out = append(c.out, ' ')
+	}
+	c.space = false
+	c.out = append(c.out, s...)
+}
+
+func (c *converter) prefix() {
+	for i := 0; i < c.quote; i++ {
+		c.out = append(c.out, "> "...)
+	}
+}
+
+// block ends the current line and makes sure n line breaks separate it from
+// what follows; n = 2 leaves a blank line.
+func (c *converter) block(n int) {
+	c.space = false
+	if len(c.out) == 0 {
+		return
+	}
+	have := 0
+	for i := len(c.out) - 1; i >= 0 && c.out[i] == '\n'; i-- {
+		have++
+	}
+	for ; have < n; have++ {
+		c.out = append(c.out, '\n')
+	}
+}
+
+func (c *converter) writePre(s string) {
+	if c.preLead {
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "\r"), "\n")
+		c.preLead = s == ""
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		if line == "" {
+			continue
+		}
+		if c.atLineStart() {
+			c.prefix()
+		}
+		c.out = append(c.out, line...)
+	}
+}
+
+type tokenKind int
+
+const (
+	textToken tokenKind = iota
+	startTag
+	endTag
+)
+
+type token struct {
+	kind        tokenKind
+	name        string // lower-case tag name
+	attrs       map[string]string
+	selfClosing bool
+	text        string // unescaped text
+}
+
+// rawText elements end only at their matching end tag; their content is not markup.
+var rawText = map[string]bool{"script": true, "style": true, "title": true, "textarea": true, "xmp": true}
+
+// tokenize splits src into text and tags, calling emit for each token.
+// Comments, doctypes and processing instructions are dropped.
+func tokenize(src string, emit func(token)) {
+	textStart := 0
+	flush := func(end int) {
+		if end > textStart {
+			emit(token{kind: textToken, text: html.UnescapeString(src[textStart:end])})
+		}
+	}
+	for i := 0; i < len(src); {
+		if src[i] != '<' || i+1 >= len(src) {
+			i++
+			continue
+		}
+		next := src[i+1]
+		switch {
+		case strings.HasPrefix(src[i:], "<!--"):
+			flush(i)
+			end := strings.Index(src[i+4:], "-->")
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += 4 + end + 3
+			}
+			textStart = i
+		case next == '!' || next == '?':
+			flush(i)
+			end := strings.IndexByte(src[i:], '>')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 1
+			}
+			textStart = i
+		case next == '/' && i+2 < len(src) && isLetter(src[i+2]):
+			flush(i)
+			name, j := tagName(src, i+2)
+			end := strings.IndexByte(src[j:], '>')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i = j + end + 1
+			}
+			textStart = i
+			emit(token{kind: endTag, name: name})
+		case isLetter(next):
+			flush(i)
+			t, j := startTagAt(src, i+1)
+			i, textStart = j, j
+			emit(t)
+			if rawText[t.name] && !t.selfClosing {
+				end := indexFold(src[i:], "</"+t.name)
+				if end < 0 {
+					end = len(src) - i
+				}
+				text := src[i : i+end]
+				if t.name == "title" || t.name == "textarea" {
+					text = html.UnescapeString(text)
+				}
+				if text != "" {
+					emit(token{kind: textToken, text: text})
+				}
+				i += end
+				textStart = i
+			}
+		default:
+			i++
+		}
+	}
+	flush(len(src))
+}
+
+func isLetter(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+}
+
+func tagName(src string, i int) (string, int) {
+	j := i
+	for j < len(src) && !isSpace(src[j]) && src[j] != '>' && src[j] != '/' {
+		j++
+	}
+	return strings.ToLower(src[i:j]), j
+}
+
+// startTagAt parses a start tag whose name begins at i and returns it with the
+// offset just past its ">".
+func startTagAt(src string, i int) (token, int) {
+	t := token{kind: startTag}
+	t.name, i = tagName(src, i)
+	for i < len(src) {
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+		if i >= len(src) {
+			break
+		}
+		switch src[i] {
+		case '>':
+			return t, i + 1
+		case '/':
+			if i+1 < len(src) && src[i+1] == '>' {
+				t.selfClosing = true
+				return t, i + 2
+			}
+			i++
+			continue
+		}
+		j := i
+		for j < len(src) && !isSpace(src[j]) && src[j] != '=' && src[j] != '>' && !(src[j] == '/' && j+1 < len(src) && src[j+1] == '>') {
+			j++
+		}
+		if j == i {
+			// a stray "=": skip it so the loop always advances
+			j++
+		}
+		key := strings.ToLower(src[i:j])
+		i = j
+		for i < len(src) && isSpace(src[i]) {
+			i++
+		}
+		val := ""
+		if i < len(src) && src[i] == '=' {
+			i++
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+			if i < len(src) && (src[i] == '"' || src[i] == '\'') {
+				q := src[i]
+				end := strings.IndexByte(src[i+1:], q)
+				if end < 0 {
+					end = len(src) - i - 1
+				}
+				val = src[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				j := i
+				for j < len(src) && !isSpace(src[j]) && src[j] != '>' {
+					j++
+				}
+				val = src[i:j]
+				i = j
+			}
+		}
+		if t.attrs == nil {
+			t.attrs = map[string]string{}
+		}
+		if _, dup := t.attrs[key]; !dup {
+			t.attrs[key] = html.UnescapeString(val)
+		}
+	}
+	return t, len(src)
+}
+
+// indexFold is strings.Index ignoring ASCII case of s.
+func indexFold(s, substr string) int {
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if strings.EqualFold(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
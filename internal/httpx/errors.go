@@ -0,0 +1,151 @@
+package httpx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// APIError is a non-2xx response (or an error event inside a stream) from a provider API.
+type APIError struct {
+	Provider   string
+	StatusCode int            // HTTP status; 0 if unknown (e.g. error event mid-stream)
+	Code       string         // provider error code/type, if any
+	Message    string         // human readable message
+	Metadata   map[string]any // provider specific details (OpenRouter error.metadata)
+	Body       []byte         // raw response body
+}
+
+func (e *APIError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Provider)
+	b.WriteString(" error")
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&b, " %d", e.StatusCode)
+	}
+	if e.Code != "" && e.Code != strconv.Itoa(e.StatusCode) {
+		fmt.Fprintf(&b, " (%s)", e.Code)
+	}
+	b.WriteString(": ")
+	b.WriteString(e.Message)
+	return b.String()
+}
+
+// NewAPIError reads res.Body and decodes the common error shapes:
+// {"error":{"code","message","metadata"}}, {"error":"..."}, {"error":[...]}, {"message":"..."} and {"detail":"..."}.
+func NewAPIError(provider string, res *http.Response) *APIError {
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 1<<20))
+	e := &APIError{Provider: provider, StatusCode: res.StatusCode, Body: b}
+	var body struct {
+		Error     json.RawMessage `json:"error"`
+		ErrorType string          `json:"error_type"`
+		Message   string          `json:"message"`
+		Detail    any             `json:"detail"`
+	}
+	if err := json.Unmarshal(b, &body); err == nil {
+		e.Code = body.ErrorType
+		var obj struct {
+			Code     any            `json:"code"`
+			Type     string         `json:"type"`
+			Message  string         `json:"message"`
+			Metadata map[string]any `json:"metadata"`
+		}
+		var str string
+		var list []string
+		switch {
+		case json.Unmarshal(body.Error, &obj) == nil && obj.Message != "":
+			e.Message, e.Metadata = obj.Message, obj.Metadata
+			if obj.Code != nil {
+				e.Code = strings.Trim(fmt.Sprint(obj.Code), `"`)
+			} else if obj.Type != "" {
+				e.Code = obj.Type
+			}
+		case json.Unmarshal(body.Error, &str) == nil && str != "":
+			e.Message = str
+		case json.Unmarshal(body.Error, &list) == nil && len(list) > 0:
+			e.Message = strings.Join(list, "; ")
+		case body.Message != "":
+			e.Message = body.Message
+		case body.Detail != nil:
+			e.Message = fmt.Sprint(body.Detail)
+		}
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(b))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(res.StatusCode)
+	}
+	return e
+}
+
+// AsAPIError unwraps err into an *APIError.
+func AsAPIError(err error) (*APIError, bool) {
+	var e *APIError
+	ok := errors.As(err, &e)
+	return e, ok
+}
+
+// text is the lowercased message plus provider metadata, used for condition matching
+// since providers report the same condition with different codes.
+func (e *APIError) text() string {
+	s := e.Message
+	if len(e.Metadata) > 0 {
+		if mb, err := json.Marshal(e.Metadata); err == nil {
+			s += " " + string(mb)
+		}
+	}
+	return strings.ToLower(s)
+}
+
+func containsAny(s string, subs ...string) bool {
+	for _, sub := range subs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsToolsUnsupported reports whether the model/endpoint rejected the request because of tools.
+func IsToolsUnsupported(err error) bool {
+	e, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return containsAny(e.text(), "support tool use", "does not support tools", "tools are not supported", "tool use is not supported", "tool calling is not supported",
+		// llama.cpp without --jinja, vLLM without --enable-auto-tool-choice
+		"tools param requires", "tool choice requires")
+}
+
+// IsInsufficientCredits reports whether the account cannot pay for the request as sent
+// (OpenRouter answers 402 and suggests fewer max_tokens).
+func IsInsufficientCredits(err error) bool {
+	e, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return e.StatusCode == http.StatusPaymentRequired || containsAny(e.text(), "requires more credits", "fewer max_tokens", "insufficient credits", "insufficient_quota")
+}
+
+// IsContextTooLong reports whether the prompt plus max tokens exceed the model context window.
+func IsContextTooLong(err error) bool {
+	e, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return e.Code == "context_length_exceeded" || containsAny(e.text(), "context length", "context_length", "maximum context", "context window", "`inputs` tokens + `max_new_tokens`")
+}
+
+// IsRateLimited reports whether the provider throttled the request.
+func IsRateLimited(err error) bool {
+	e, ok := AsAPIError(err)
+	if !ok {
+		return false
+	}
+	return e.StatusCode == http.StatusTooManyRequests || e.Code == "rate_limit_exceeded"
+}
@@ -0,0 +1,87 @@
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// ErrStalled is reported when a streaming response sends nothing for too long.
+var ErrStalled = errors.New("stream stalled")
+
+// IdleTimeout bounds the silence of a streaming call. Its context is cancelled
+// when nothing arrives for the set duration: neither the response headers of an
+// attempt nor data of the body. The timer restarts with every attempt and every
+// read that returns data.
+type IdleTimeout struct {
+	d       time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+// NewIdleTimeout starts the timer and returns the context to send the call with.
+// A duration <= 0 disables the timeout.
+func NewIdleTimeout(ctx context.Context, d time.Duration) (*IdleTimeout, context.Context) {
+	t := &IdleTimeout{d: d}
+	if d <= 0 {
+		return t, ctx
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	t.timer = time.AfterFunc(d, func() {
+		t.expired.Store(true)
+		cancel()
+	})
+	return t, ctx
+}
+
+// Touch restarts the timer.
+func (t *IdleTimeout) Touch() {
+	if t.timer != nil && !t.expired.Load() {
+		t.timer.Reset(t.d)
+	}
+}
+
+// Request wraps a request factory for Do so that every attempt restarts the timer.
+func (t *IdleTimeout) Request(newReq func() (*http.Request, error)) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		t.Touch()
+		return newReq()
+	}
+}
+
+// Body wraps a response body so that every read with data restarts the timer.
+func (t *IdleTimeout) Body(r io.Reader) io.Reader {
+	return idleReader{r: r, t: t}
+}
+
+// Stop releases the timer; call it when the stream is done.
+func (t *IdleTimeout) Stop() {
+	if t.timer != nil {
+		t.timer.Stop()
+	}
+}
+
+// Err replaces the cancellation error caused by the timeout with ErrStalled.
+func (t *IdleTimeout) Err(err error) error {
+	if err != nil && t.expired.Load() {
+		return fmt.Errorf("%w: no data from the server for %s", ErrStalled, t.d)
+	}
+	return err
+}
+
+type idleReader struct {
+	r io.Reader
+	t *IdleTimeout
+}
+
+func (r idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.Touch()
+	}
+	return n, err
+}
@@ -0,0 +1,150 @@
+package httpx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how a failed HTTP call is retried. Network errors, 429 and 5xx
+// responses are retried; everything else is returned to the caller as is.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts including the first one; <= 1 disables retries
+	BaseDelay   time.Duration // first backoff step, doubled on every attempt
+	MaxDelay    time.Duration // upper bound for a single wait, server hints included
+}
+
+// DefaultRetryPolicy is used when the context carries no policy.
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 4, BaseDelay: 500 * time.Millisecond, MaxDelay: 30 * time.Second}
+
+// NoRetry sends the request exactly once.
+var NoRetry = RetryPolicy{MaxAttempts: 1}
+
+type policyKey struct{}
+
+// WithRetryPolicy returns a context that makes calls made with it use p.
+func WithRetryPolicy(ctx context.Context, p RetryPolicy) context.Context {
+	return context.WithValue(ctx, policyKey{}, p)
+}
+
+// RetryPolicyFrom returns the policy stored in ctx or DefaultRetryPolicy.
+func RetryPolicyFrom(ctx context.Context) RetryPolicy {
+	if p, ok := ctx.Value(policyKey{}).(RetryPolicy); ok {
+		return p
+	}
+	return DefaultRetryPolicy
+}
+
+// Do sends the request produced by newReq using the retry policy from ctx.
+// newReq is called for every attempt so request bodies can be replayed.
+// The response of the last attempt is returned unread, whatever its status.
+func Do(ctx context.Context, client *http.Client, newReq func() (*http.Request, error)) (*http.Response, error) {
+	p := RetryPolicyFrom(ctx)
+	for attempt := 1; ; attempt++ {
+		req, err := newReq()
+		if err != nil {
+			return nil, err
+		}
+		res, err := client.Do(req)
+		last := attempt >= p.MaxAttempts
+		if err != nil {
+			var perm permanentError
+			if last || ctx.Err() != nil || errors.As(err, &perm) {
+				return nil, err
+			}
+			if err := sleep(ctx, p.backoff(attempt)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if last || !retryableStatus(res.StatusCode) {
+			return res, nil
+		}
+		wait := p.backoff(attempt)
+		if hint, ok := serverDelay(res); ok {
+			wait = hint
+		}
+		if p.MaxDelay > 0 && wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+		res.Body.Close()
+		if err := sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// permanentError marks a failure that another attempt would repeat.
+type permanentError struct{ error }
+
+func (e permanentError) Unwrap() error { return e.error }
+
+// Permanent marks err, returned by a transport, dialer or CheckRedirect, as not
+// worth retrying: Do returns it at once.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// backoff returns an exponential delay with jitter in [d/2, d].
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	if d <= 0 {
+		d = DefaultRetryPolicy.BaseDelay
+	}
+	for i := 1; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// serverDelay extracts a wait hint from Retry-After (seconds or HTTP date) or,
+// for HF "model is loading" responses, from the estimated_time body field.
+// It consumes the body.
+func serverDelay(res *http.Response) (time.Duration, bool) {
+	if ra := res.Header.Get("Retry-After"); ra != "" {
+		if secs, err := strconv.Atoi(ra); err == nil && secs >= 0 {
+			return time.Duration(secs) * time.Second, true
+		}
+		if t, err := http.ParseTime(ra); err == nil {
+			return time.Until(t), true
+		}
+	}
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64*1024))
+	var body struct {
+		EstimatedTime float64 `json:"estimated_time"`
+	}
+	if json.Unmarshal(bytes.TrimSpace(b), &body) == nil && body.EstimatedTime > 0 {
+		return time.Duration(body.EstimatedTime * float64(time.Second)), true
+	}
+	return 0, false
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -7,16 +7,92 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"agent_challenge/internal/httpx"
 )
 
-const baseURL = "https://api-inference.huggingface.co/models/"
-const hubAPI = "https://huggingface.co/api/models"
+const DefaultBaseURL = "https://api-inference.huggingface.co/models/"
+const DefaultHubURL = "https://huggingface.co/api/models"
+
+// Client is a reusable Inference API / Hub client. It is safe for concurrent use and
+// shares the underlying connection pool between calls.
+type Client struct {
+	baseURL string // model id is appended
+	hubURL  string
+	token   string
+	headers http.Header
+	http    *http.Client  // non-streaming calls, bounded by Timeout
+	stream  *http.Client  // same transport, no timeout: streams are bounded by ctx and idle
+	idle    time.Duration // longest silence of a stream, the Timeout of non-streaming calls
+}
+
+type Option func(*Client)
+
+// WithBaseURL points text generation at another endpoint root, e.g. a self-hosted TGI gateway.
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimRight(u, "/") + "/" }
+}
+
+// WithHubURL sets the models listing endpoint (default DefaultHubURL).
+func WithHubURL(u string) Option {
+	return func(c *Client) { c.hubURL = u }
+}
+
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTimeout limits a single non-streaming attempt and how long a stream may send
+// nothing. Default is 60s.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.http.Timeout = d }
+}
+
+// WithTransport sets a custom RoundTripper (proxy, TLS settings, request logging).
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.http.Transport = rt }
+}
+
+// WithHTTPClient uses a copy of hc for all calls.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.http = &cp
+	}
+}
+
+// WithHeader adds a header sent with every request.
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Set(key, value) }
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL: DefaultBaseURL,
+		hubURL:  DefaultHubURL,
+		headers: http.Header{},
+		http:    &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	sc := *c.http
+	sc.Timeout = 0
+	c.stream = &sc
+	c.idle = c.http.Timeout
+	return c
+}
+
+// HasToken reports whether requests are authenticated.
+func (c *Client) HasToken() bool { return c.token != "" }
 
 type requestBody struct {
 	Inputs     string                 `json:"inputs"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
 	Options    map[string]interface{} `json:"options,omitempty"`
+	Stream     bool                   `json:"stream,omitempty"`
 }
 
 // Generic HF text generation response (best-effort). Different backends return different shapes.
@@ -35,7 +111,7 @@ type genTextRich struct {
 }
 
 type Options struct {
-	Temperature  float64
+	Temperature  float64 // 0 selects greedy decoding
 	MaxNewTokens int
 	Stop         []string
 	TopP         float64
@@ -44,9 +120,12 @@ type Options struct {
 type Result struct {
 	Text         string
 	FinishReason string
+	// Set by GenerateStream only
+	Tokens           int
+	TimeToFirstToken time.Duration
 }
 
-func Generate(ctx context.Context, token, model, prompt string, opts Options) (*Result, error) {
+func newRequestBody(prompt string, opts Options) requestBody {
 	rb := requestBody{Inputs: prompt}
 	rb.Parameters = map[string]interface{}{
 		"return_full_text": false,
@@ -56,6 +135,9 @@ func Generate(ctx context.Context, token, model, prompt string, opts Options) (*
 	}
 	if opts.Temperature > 0 {
 		rb.Parameters["temperature"] = opts.Temperature
+	} else {
+		// the API refuses a temperature of 0; greedy decoding is what it means
+		rb.Parameters["do_sample"] = false
 	}
 	if opts.TopP > 0 {
 		rb.Parameters["top_p"] = opts.TopP
@@ -65,27 +147,21 @@ func Generate(ctx context.Context, token, model, prompt string, opts Options) (*
 	}
 	// Inference API may queue cold models; set wait_for_model to true
 	rb.Options = map[string]interface{}{"wait_for_model": true}
+	return rb
+}
 
-	buf := new(bytes.Buffer)
-	if err := json.NewEncoder(buf).Encode(rb); err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+model, buf)
+func (c *Client) Generate(ctx context.Context, model, prompt string, opts Options) (*Result, error) {
+	body, err := json.Marshal(newRequestBody(prompt, opts))
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodPost, c.baseURL+model, body))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		b, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("huggingface error: %s", string(b))
+		return nil, httpx.NewAPIError("huggingface", res)
 	}
 	// Try to decode a few possible shapes
 	var anyResp any
@@ -118,6 +194,30 @@ func Generate(ctx context.Context, token, model, prompt string, opts Options) (*
 	return &Result{Text: string(jb)}, nil
 }
 
+// newRequest returns a request factory for httpx.Do so the body can be replayed on retries.
+func (c *Client) newRequest(ctx context.Context, method, url string, body []byte) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, url, rd)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range c.headers {
+			req.Header[k] = v
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	}
+}
+
 // ---- List public text-generation models (non-gated/non-private) from the Hub ----
 type hubModel struct {
 	ID          string `json:"id"`
@@ -126,27 +226,18 @@ type hubModel struct {
 	Gated       bool   `json:"gated"`
 }
 
-func ListTextGenModels(ctx context.Context, token string, limit int) ([]string, error) {
+func (c *Client) ListTextGenModels(ctx context.Context, limit int) ([]string, error) {
 	if limit <= 0 {
 		limit = 50
 	}
-	url := fmt.Sprintf("%s?pipeline_tag=text-generation&sort=likes&direction=-1&limit=%d", hubAPI, limit)
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
-	if err != nil {
-		return nil, err
-	}
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
-	}
-	client := &http.Client{Timeout: 30 * time.Second}
-	res, err := client.Do(req)
+	url := fmt.Sprintf("%s?pipeline_tag=text-generation&sort=likes&direction=-1&limit=%d", c.hubURL, limit)
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodGet, url, nil))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		b, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("huggingface hub error: %s", string(b))
+		return nil, httpx.NewAPIError("huggingface hub", res)
 	}
 	var arr []hubModel
 	if err := json.NewDecoder(res.Body).Decode(&arr); err != nil {
@@ -0,0 +1,17 @@
+package huggingface
+
+import "testing"
+
+func TestRequestBodyTemperature(t *testing.T) {
+	rb := newRequestBody("hi", Options{Temperature: 0})
+	if _, ok := rb.Parameters["temperature"]; ok || rb.Parameters["do_sample"] != false {
+		t.Errorf("temperature 0: parameters %v, want greedy decoding", rb.Parameters)
+	}
+	rb = newRequestBody("hi", Options{Temperature: 0.7})
+	if rb.Parameters["temperature"] != 0.7 {
+		t.Errorf("temperature 0.7: parameters %v", rb.Parameters)
+	}
+	if _, ok := rb.Parameters["do_sample"]; ok {
+		t.Errorf("temperature 0.7: parameters %v disable sampling", rb.Parameters)
+	}
+}
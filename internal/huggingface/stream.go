@@ -0,0 +1,95 @@
+package huggingface
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent_challenge/internal/httpx"
+)
+
+// TGI server-sent event payload for stream=true.
+type streamEvent struct {
+	Token struct {
+		ID      int    `json:"id"`
+		Text    string `json:"text"`
+		Special bool   `json:"special"`
+	} `json:"token"`
+	GeneratedText *string         `json:"generated_text"`
+	Details       *genTextDetails `json:"details"`
+	Error         string          `json:"error"`
+	ErrorType     string          `json:"error_type"`
+}
+
+// GenerateStream is Generate with stream=true: onToken receives each generated piece of text
+// as it arrives. The returned Result also reports the token count and time to first token.
+func (c *Client) GenerateStream(ctx context.Context, model, prompt string, opts Options, onToken func(string)) (*Result, error) {
+	rb := newRequestBody(prompt, opts)
+	rb.Stream = true
+	body, err := json.Marshal(rb)
+	if err != nil {
+		return nil, err
+	}
+
+	idle, ctx := httpx.NewIdleTimeout(ctx, c.idle)
+	defer idle.Stop()
+	newReq := c.newRequest(ctx, http.MethodPost, c.baseURL+model, body)
+	start := time.Now()
+	res, err := httpx.Do(ctx, c.stream, idle.Request(func() (*http.Request, error) {
+		req, err := newReq()
+		if err == nil {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+		return req, err
+	}))
+	if err != nil {
+		return nil, idle.Err(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, httpx.NewAPIError("huggingface", res)
+	}
+
+	var (
+		result Result
+		text   strings.Builder
+	)
+	sc := bufio.NewScanner(idle.Body(res.Body))
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		var ev streamEvent
+		if err := json.Unmarshal([]byte(strings.TrimSpace(strings.TrimPrefix(line, "data:"))), &ev); err != nil {
+			return nil, fmt.Errorf("huggingface stream decode: %w", err)
+		}
+		if ev.Error != "" {
+			return nil, &httpx.APIError{Provider: "huggingface", Code: ev.ErrorType, Message: ev.Error, Body: []byte(line)}
+		}
+		if ev.Details != nil {
+			result.FinishReason = ev.Details.FinishReason
+		}
+		if ev.Token.Special || ev.Token.Text == "" {
+			continue
+		}
+		if result.Tokens == 0 {
+			result.TimeToFirstToken = time.Since(start)
+		}
+		result.Tokens++
+		text.WriteString(ev.Token.Text)
+		if onToken != nil {
+			onToken(ev.Token.Text)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, idle.Err(err)
+	}
+	result.Text = text.String()
+	return &result, nil
+}
@@ -0,0 +1,404 @@
+// Package mcp is a client for Model Context Protocol servers that run as child
+// processes and speak JSON-RPC 2.0 over stdio, one message per line. Only the
+// tools capability is used: tools/list and tools/call.
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"agent_challenge/internal/safeenv"
+)
+
+// ProtocolVersion is the MCP revision the client asks for.
+const ProtocolVersion = "2024-11-05"
+
+// Tool is a tool advertised by a server.
+type Tool struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	InputSchema map[string]any `json:"inputSchema"`
+}
+
+// Content is an item of a tool result.
+type Content struct {
+	Type     string    `json:"type"` // text, image, audio or resource
+	Text     string    `json:"text,omitempty"`
+	MimeType string    `json:"mimeType,omitempty"`
+	Resource *Resource `json:"resource,omitempty"`
+}
+
+// Resource is an embedded resource in a tool result.
+type Resource struct {
+	URI      string `json:"uri"`
+	MimeType string `json:"mimeType,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// CallResult is the result of tools/call. IsError marks a failure of the tool
+// itself, reported in Content, as opposed to a protocol error.
+type CallResult struct {
+	Content []Content `json:"content"`
+	IsError bool      `json:"isError,omitempty"`
+}
+
+// Text joins the content into plain text; non-text items are shown as placeholders.
+func (r *CallResult) Text() string {
+	var parts []string
+	for _, c := range r.Content {
+		switch {
+		case c.Type == "text":
+			parts = append(parts, c.Text)
+		case c.Type == "resource" && c.Resource != nil && c.Resource.Text != "":
+			parts = append(parts, c.Resource.Text)
+		case c.Type == "resource" && c.Resource != nil:
+			parts = append(parts, fmt.Sprintf("[resource %s]", c.Resource.URI))
+		default:
+			parts = append(parts, fmt.Sprintf("[%s %s]", c.Type, c.MimeType))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// RPCError is a JSON-RPC error returned by the server.
+type RPCError struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// message is any JSON-RPC message: a request has Method and ID, a notification
+// only Method, a response ID and Result or Error.
+type message struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method,omitempty"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// Client is a connection to one server process. It is safe for concurrent use.
+type Client struct {
+	name string
+	cmd  *exec.Cmd
+	in   io.WriteCloser
+
+	// ServerName and ServerVersion are reported by the server on initialize.
+	ServerName    string
+	ServerVersion string
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan message
+	done    chan struct{} // closed when the server's stdout ends
+	err     error         // why it ended
+	stderr  tailBuffer
+}
+
+// Start runs the server and performs the initialize handshake. ctx bounds the
+// handshake only; the process lives until Close.
+func Start(ctx context.Context, cfg ServerConfig) (*Client, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("mcp server %s: command is required", cfg.Name)
+	}
+	cmd := exec.Command(cfg.Command, cfg.Args...)
+	cmd.Dir = cfg.Dir
+	// like run_command, servers do not see the API keys of the agent; a server
+	// that needs a key gets it from its env in the config
+	cmd.Env = safeenv.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	c := newClient(cfg.Name, in)
+	c.cmd = cmd
+	cmd.Stderr = &c.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %w", cfg.Name, err)
+	}
+	go c.readLoop(out)
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Connect performs the handshake with a server that is already running, e.g. in
+// the same process: the client writes to in and reads from out. Close only
+// closes in.
+func Connect(ctx context.Context, name string, in io.WriteCloser, out io.Reader) (*Client, error) {
+	c := newClient(name, in)
+	go c.readLoop(out)
+	if err := c.initialize(ctx); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func newClient(name string, in io.WriteCloser) *Client {
+	return &Client{name: name, in: in, pending: map[int64]chan message{}, done: make(chan struct{})}
+}
+
+func (c *Client) initialize(ctx context.Context) error {
+	var init struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		ServerInfo      struct {
+			Name    string `json:"name"`
+			Version string `json:"version"`
+		} `json:"serverInfo"`
+	}
+	err := c.call(ctx, "initialize", map[string]any{
+		"protocolVersion": ProtocolVersion,
+		"capabilities":    map[string]any{},
+		"clientInfo":      map[string]any{"name": "agent_challenge", "version": "1.0"},
+	}, &init)
+	if err == nil {
+		err = c.notify("notifications/initialized", nil)
+	}
+	if err != nil {
+		return fmt.Errorf("mcp server %s: initialize: %w", c.name, err)
+	}
+	c.ServerName, c.ServerVersion = init.ServerInfo.Name, init.ServerInfo.Version
+	return nil
+}
+
+// Name is the server name from the configuration.
+func (c *Client) Name() string { return c.name }
+
+// ListTools returns all tools of the server, following pagination.
+func (c *Client) ListTools(ctx context.Context) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		params := map[string]any{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools      []Tool `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := c.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("mcp server %s: tools/list: %w", c.name, err)
+		}
+		tools = append(tools, page.Tools...)
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// CallTool calls a tool with JSON object arguments.
+func (c *Client) CallTool(ctx context.Context, name string, args json.RawMessage) (*CallResult, error) {
+	if len(strings.TrimSpace(string(args))) == 0 {
+		args = json.RawMessage("{}")
+	}
+	var res CallResult
+	if err := c.call(ctx, "tools/call", map[string]any{"name": name, "arguments": args}, &res); err != nil {
+		return nil, fmt.Errorf("mcp server %s: %s: %w", c.name, name, err)
+	}
+	return &res, nil
+}
+
+// Close stops the server: stdin is closed and the process is killed if it
+// does not exit within two seconds.
+func (c *Client) Close() error {
+	c.in.Close()
+	if c.cmd == nil {
+		return nil
+	}
+	exited := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(exited)
+	}()
+	select {
+	case <-exited:
+	case <-time.After(2 * time.Second):
+		c.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// call sends a request and decodes its result into out.
+func (c *Client) call(ctx context.Context, method string, params, out any) error {
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.exitErr()
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan message, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(map[string]any{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		return err
+	}
+	select {
+	case m := <-ch:
+		if m.Error != nil {
+			return m.Error
+		}
+		if out == nil {
+			return nil
+		}
+		if err := json.Unmarshal(m.Result, out); err != nil {
+			return fmt.Errorf("invalid result: %w", err)
+		}
+		return nil
+	case <-c.done:
+		return c.exitErr()
+	case <-ctx.Done():
+		c.notify("notifications/cancelled", map[string]any{"requestId": id, "reason": ctx.Err().Error()})
+		return ctx.Err()
+	}
+}
+
+func (c *Client) notify(method string, params any) error {
+	m := map[string]any{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		m["params"] = params
+	}
+	return c.send(m)
+}
+
+func (c *Client) send(m any) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if _, err := c.in.Write(append(b, '\n')); err != nil {
+		select {
+		case <-c.done:
+			return c.exitErr()
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// readLoop dispatches messages from the server until its stdout ends.
+func (c *Client) readLoop(r io.Reader) {
+	br := bufio.NewReader(r)
+	var err error
+	for {
+		var line []byte
+		line, err = br.ReadBytes('\n')
+		if len(strings.TrimSpace(string(line))) > 0 {
+			c.handle(line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = errors.New("server exited")
+	}
+	c.mu.Lock()
+	c.err = err
+	c.mu.Unlock()
+	close(c.done)
+}
+
+func (c *Client) handle(line []byte) {
+	var m message
+	if err := json.Unmarshal(line, &m); err != nil {
+		// servers sometimes print logs to stdout; keep them with stderr
+		c.stderr.Write(line)
+		return
+	}
+	switch {
+	case m.Method != "" && len(m.ID) > 0:
+		// requests from the server: answer ping, refuse the rest
+		reply := map[string]any{"jsonrpc": "2.0", "id": m.ID}
+		if m.Method == "ping" {
+			reply["result"] = map[string]any{}
+		} else {
+			reply["error"] = RPCError{Code: -32601, Message: "method not found: " + m.Method}
+		}
+		c.send(reply)
+	case m.Method != "":
+		// notifications (logging, list_changed) are not used
+	default:
+		var id int64
+		if err := json.Unmarshal(m.ID, &id); err != nil {
+			return
+		}
+		c.mu.Lock()
+		ch := c.pending[id]
+		c.mu.Unlock()
+		if ch != nil {
+			ch <- m
+		}
+	}
+}
+
+// exitErr describes why the server stopped, with the end of its stderr.
+func (c *Client) exitErr() error {
+	c.mu.Lock()
+	err := c.err
+	c.mu.Unlock()
+	if tail := strings.TrimSpace(c.stderr.String()); tail != "" {
+		return fmt.Errorf("%w: %s", err, tail)
+	}
+	return err
+}
+
+// tailBuffer keeps the last bytes written to it.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+const tailSize = 2048
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buf = append(t.buf, p...)
+	if len(t.buf) > tailSize {
+		t.buf = append([]byte(nil), t.buf[len(t.buf)-tailSize:]...)
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.ToValidUTF8(string(t.buf), "")
+}
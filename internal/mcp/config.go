@@ -0,0 +1,49 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// ServerConfig describes how to run a server.
+type ServerConfig struct {
+	Name    string            `json:"-"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"` // added to the environment of the agent, which has no secrets
+	Dir     string            `json:"cwd"` // working directory, relative to the config file
+}
+
+// LoadConfig reads a config file in the format used by other MCP clients:
+//
+//	{"mcpServers": {"files": {"command": "npx", "args": ["-y", "@modelcontextprotocol/server-filesystem", "."]}}}
+//
+// Servers are returned sorted by name.
+func LoadConfig(file string) ([]ServerConfig, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var cfg struct {
+		Servers map[string]ServerConfig `json:"mcpServers"`
+	}
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	servers := make([]ServerConfig, 0, len(cfg.Servers))
+	for name, s := range cfg.Servers {
+		if s.Command == "" {
+			return nil, fmt.Errorf("%s: server %s: command is required", file, name)
+		}
+		s.Name = name
+		if s.Dir != "" && !filepath.IsAbs(s.Dir) {
+			s.Dir = filepath.Join(filepath.Dir(file), s.Dir)
+		}
+		servers = append(servers, s)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Name < servers[j].Name })
+	return servers, nil
+}
@@ -0,0 +1,177 @@
+package mcp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// testServer has echo, fail and wait; wait blocks until its call is cancelled
+// and reports that on cancelled.
+func testServer(started, cancelled chan<- struct{}) *Server {
+	return &Server{
+		Name:    "test-server",
+		Version: "0.1",
+		ListTools: func() []Tool {
+			return []Tool{
+				{Name: "echo", Description: "Echoes text", InputSchema: map[string]any{
+					"type":       "object",
+					"properties": map[string]any{"text": map[string]any{"type": "string"}},
+				}},
+				{Name: "fail", InputSchema: map[string]any{"type": "object"}},
+				{Name: "wait", InputSchema: map[string]any{"type": "object"}},
+			}
+		},
+		CallTool: func(ctx context.Context, name string, args json.RawMessage) (*CallResult, error) {
+			switch name {
+			case "echo":
+				var a struct {
+					Text string `json:"text"`
+				}
+				if err := json.Unmarshal(args, &a); err != nil {
+					return nil, err
+				}
+				return &CallResult{Content: []Content{{Type: "text", Text: a.Text}}}, nil
+			case "fail":
+				return &CallResult{Content: []Content{{Type: "text", Text: "boom"}}, IsError: true}, nil
+			case "wait":
+				started <- struct{}{}
+				<-ctx.Done()
+				cancelled <- struct{}{}
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("unknown tool %s", name)
+		},
+	}
+}
+
+// connectPipe serves s over a pair of pipes and connects a client to it.
+func connectPipe(t *testing.T, s *Server) *Client {
+	t.Helper()
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(context.Background(), serverIn, serverOut)
+		serverOut.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := Connect(ctx, "test", clientOut, clientIn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	t.Cleanup(func() {
+		c.Close()
+		select {
+		case err := <-served:
+			if err != nil {
+				t.Errorf("Serve: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("Serve did not return after the client closed")
+		}
+	})
+	return c
+}
+
+func TestInitialize(t *testing.T) {
+	c := connectPipe(t, testServer(nil, nil))
+	if c.ServerName != "test-server" || c.ServerVersion != "0.1" {
+		t.Errorf("server info = %q %q", c.ServerName, c.ServerVersion)
+	}
+	if c.Name() != "test" {
+		t.Errorf("Name() = %q", c.Name())
+	}
+}
+
+func TestListTools(t *testing.T) {
+	c := connectPipe(t, testServer(nil, nil))
+	tools, err := c.ListTools(context.Background())
+	if err != nil {
+		t.Fatalf("ListTools: %v", err)
+	}
+	if len(tools) != 3 || tools[0].Name != "echo" || tools[1].Name != "fail" || tools[2].Name != "wait" {
+		t.Fatalf("tools = %+v", tools)
+	}
+	if tools[0].Description != "Echoes text" || tools[0].InputSchema["type"] != "object" {
+		t.Errorf("echo = %+v", tools[0])
+	}
+}
+
+func TestCallTool(t *testing.T) {
+	c := connectPipe(t, testServer(nil, nil))
+	ctx := context.Background()
+
+	res, err := c.CallTool(ctx, "echo", json.RawMessage(`{"text":"привет"}`))
+	if err != nil {
+		t.Fatalf("echo: %v", err)
+	}
+	if res.IsError || res.Text() != "привет" {
+		t.Errorf("echo = %+v", res)
+	}
+
+	res, err = c.CallTool(ctx, "fail", nil)
+	if err != nil {
+		t.Fatalf("fail: %v", err)
+	}
+	if !res.IsError || res.Text() != "boom" {
+		t.Errorf("fail = %+v", res)
+	}
+
+	_, err = c.CallTool(ctx, "missing", nil)
+	var rpcErr *RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != codeInvalidParams {
+		t.Errorf("missing tool: error = %v", err)
+	}
+}
+
+func TestCallToolCancel(t *testing.T) {
+	started, cancelled := make(chan struct{}, 1), make(chan struct{}, 1)
+	c := connectPipe(t, testServer(started, cancelled))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	_, err := c.CallTool(ctx, "wait", nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("CallTool error = %v, want context.Canceled", err)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not cancel the tool call")
+	}
+
+	// the connection stays usable
+	res, err := c.CallTool(context.Background(), "echo", json.RawMessage(`{"text":"ok"}`))
+	if err != nil || res.Text() != "ok" {
+		t.Errorf("echo after cancel = %+v, %v", res, err)
+	}
+}
+
+func TestServerGone(t *testing.T) {
+	clientIn, serverOut := io.Pipe()
+	serverIn, clientOut := io.Pipe()
+	go func() {
+		testServer(nil, nil).Serve(context.Background(), serverIn, serverOut)
+		serverOut.Close()
+	}()
+	c, err := Connect(context.Background(), "test", clientOut, clientIn)
+	if err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	serverOut.Close()
+	serverIn.Close()
+	<-c.done
+	if _, err := c.CallTool(context.Background(), "echo", nil); err == nil {
+		t.Error("CallTool succeeded after the server went away")
+	}
+}
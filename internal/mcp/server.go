@@ -0,0 +1,178 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+// JSON-RPC error codes used by the server.
+const (
+	codeParseError     = -32700
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+)
+
+// Server exposes tools over stdio to an MCP client.
+type Server struct {
+	Name    string
+	Version string
+	// ListTools returns the tools to advertise.
+	ListTools func() []Tool
+	// CallTool runs a tool. Failures of the tool belong in a result with IsError;
+	// an error means the call itself was invalid, e.g. the tool does not exist.
+	CallTool func(ctx context.Context, name string, args json.RawMessage) (*CallResult, error)
+}
+
+// Serve answers requests read from in, one JSON-RPC message per line, until in
+// ends or ctx is done. Tool calls run concurrently and can be cancelled by the
+// client with notifications/cancelled.
+func (s *Server) Serve(ctx context.Context, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		writeMu  sync.Mutex
+		mu       sync.Mutex
+		inflight = map[string]context.CancelFunc{}
+		wg       sync.WaitGroup
+	)
+	write := func(m any) {
+		b, err := json.Marshal(m)
+		if err != nil {
+			return
+		}
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		out.Write(append(b, '\n'))
+	}
+	reply := func(id json.RawMessage, result any) {
+		write(map[string]any{"jsonrpc": "2.0", "id": id, "result": result})
+	}
+	fail := func(id json.RawMessage, code int, msg string) {
+		if id == nil {
+			id = json.RawMessage("null")
+		}
+		write(map[string]any{"jsonrpc": "2.0", "id": id, "error": RPCError{Code: code, Message: msg}})
+	}
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(in)
+		for {
+			line, err := br.ReadBytes('\n')
+			if len(strings.TrimSpace(string(line))) > 0 {
+				select {
+				case lines <- line:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	for {
+		var line []byte
+		select {
+		case line = <-lines:
+		case err := <-readErr:
+			// answer the calls still running: the client may be reading after closing its end
+			wg.Wait()
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		case <-ctx.Done():
+			wg.Wait()
+			return ctx.Err()
+		}
+		var m message
+		if err := json.Unmarshal(line, &m); err != nil {
+			fail(nil, codeParseError, "parse error: "+err.Error())
+			continue
+		}
+		if len(m.ID) == 0 {
+			// notifications
+			if m.Method == "notifications/cancelled" {
+				var p struct {
+					RequestID json.RawMessage `json:"requestId"`
+				}
+				json.Unmarshal(m.Params, &p)
+				mu.Lock()
+				if stop, ok := inflight[string(p.RequestID)]; ok {
+					stop()
+				}
+				mu.Unlock()
+			}
+			continue
+		}
+		switch m.Method {
+		case "initialize":
+			var p struct {
+				ProtocolVersion string `json:"protocolVersion"`
+			}
+			json.Unmarshal(m.Params, &p)
+			version := ProtocolVersion
+			if p.ProtocolVersion != "" && p.ProtocolVersion < ProtocolVersion {
+				// an older client: speak its revision, our subset is the same
+				version = p.ProtocolVersion
+			}
+			reply(m.ID, map[string]any{
+				"protocolVersion": version,
+				"capabilities":    map[string]any{"tools": map[string]any{"listChanged": false}},
+				"serverInfo":      map[string]any{"name": s.Name, "version": s.Version},
+			})
+		case "ping":
+			reply(m.ID, map[string]any{})
+		case "tools/list":
+			tools := s.ListTools()
+			if tools == nil {
+				tools = []Tool{}
+			}
+			reply(m.ID, map[string]any{"tools": tools})
+		case "tools/call":
+			var p struct {
+				Name      string          `json:"name"`
+				Arguments json.RawMessage `json:"arguments"`
+			}
+			if err := json.Unmarshal(m.Params, &p); err != nil || p.Name == "" {
+				fail(m.ID, codeInvalidParams, "params must contain the tool name")
+				continue
+			}
+			callCtx, stop := context.WithCancel(ctx)
+			key := string(m.ID)
+			mu.Lock()
+			inflight[key] = stop
+			mu.Unlock()
+			wg.Add(1)
+			go func(id json.RawMessage) {
+				defer wg.Done()
+				defer func() {
+					mu.Lock()
+					delete(inflight, key)
+					mu.Unlock()
+					stop()
+				}()
+				res, err := s.CallTool(callCtx, p.Name, p.Arguments)
+				switch {
+				case callCtx.Err() != nil:
+					// the client gave up on the call; it expects no response
+				case err != nil:
+					fail(id, codeInvalidParams, err.Error())
+				default:
+					reply(id, res)
+				}
+			}(m.ID)
+		default:
+			fail(m.ID, codeMethodNotFound, "method not found: "+m.Method)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package ollama
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"agent_challenge/internal/httpx"
+)
+
+// DefaultBaseURL is where a local `ollama serve` listens.
+const DefaultBaseURL = "http://localhost:11434"
+
+// Client talks to the Ollama native API (/api/chat, /api/tags). It is safe for
+// concurrent use and shares the underlying connection pool between calls.
+type Client struct {
+	baseURL string
+	headers http.Header
+	http    *http.Client  // non-streaming calls, bounded by Timeout
+	stream  *http.Client  // same transport, no timeout: streams are bounded by ctx and idle
+	idle    time.Duration // longest silence of a stream, the Timeout of non-streaming calls
+}
+
+type Option func(*Client)
+
+// WithBaseURL sets the server address; a bare host:port (as in OLLAMA_HOST) gets an http:// scheme.
+func WithBaseURL(u string) Option {
+	return func(c *Client) {
+		if !strings.Contains(u, "://") {
+			u = "http://" + u
+		}
+		c.baseURL = strings.TrimRight(u, "/")
+	}
+}
+
+// WithTimeout limits a single non-streaming attempt and how long a stream may send
+// nothing. Default is 5m: local models load slowly.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.http.Timeout = d }
+}
+
+// WithTransport sets a custom RoundTripper.
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.http.Transport = rt }
+}
+
+// WithHTTPClient uses a copy of hc for all calls.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.http = &cp
+	}
+}
+
+// WithHeader adds a header sent with every request (e.g. auth for a reverse proxy).
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Set(key, value) }
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		baseURL: DefaultBaseURL,
+		headers: http.Header{},
+		http:    &http.Client{Timeout: 5 * time.Minute},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	sc := *c.http
+	sc.Timeout = 0
+	c.stream = &sc
+	c.idle = c.http.Timeout
+	return c
+}
+
+// Chat API types
+
+type ToolFunction struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters,omitempty"`
+}
+
+type Tool struct {
+	Type     string       `json:"type"`
+	Function ToolFunction `json:"function"`
+}
+
+// ToolCallFunction carries decoded arguments, unlike the OpenAI schema's JSON string.
+type ToolCallFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+type ToolCall struct {
+	Function ToolCallFunction `json:"function"`
+}
+
+type Message struct {
+	Role      string     `json:"role"`
+	Content   string     `json:"content"`
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	ToolName  string     `json:"tool_name,omitempty"` // role "tool": which tool produced the result
+}
+
+type ChatRequest struct {
+	Model    string         `json:"model"`
+	Messages []Message      `json:"messages"`
+	Tools    []Tool         `json:"tools,omitempty"`
+	Format   any            `json:"format,omitempty"` // "json" or a JSON schema
+	Options  map[string]any `json:"options,omitempty"`
+	Stream   bool           `json:"stream"` // server default is true, so always sent
+}
+
+type ChatResponse struct {
+	Model           string  `json:"model"`
+	CreatedAt       string  `json:"created_at"`
+	Message         Message `json:"message"`
+	Done            bool    `json:"done"`
+	DoneReason      string  `json:"done_reason,omitempty"`
+	PromptEvalCount int     `json:"prompt_eval_count,omitempty"`
+	EvalCount       int     `json:"eval_count,omitempty"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type Model struct {
+	Name       string `json:"name"`
+	Model      string `json:"model"`
+	Size       int64  `json:"size"`
+	ModifiedAt string `json:"modified_at"`
+}
+
+type tagsResponse struct {
+	Models []Model `json:"models"`
+}
+
+// newRequest returns a request factory for httpx.Do so the body can be replayed on retries.
+func (c *Client) newRequest(ctx context.Context, method, path string, body []byte) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, rd)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range c.headers {
+			req.Header[k] = v
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		return req, nil
+	}
+}
+
+// ListModels returns the locally pulled models from /api/tags.
+func (c *Client) ListModels(ctx context.Context) ([]Model, error) {
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodGet, "/api/tags", nil))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, httpx.NewAPIError("ollama", res)
+	}
+	var tr tagsResponse
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return nil, err
+	}
+	return tr.Models, nil
+}
+
+func (c *Client) Chat(ctx context.Context, reqBody ChatRequest) (*ChatResponse, error) {
+	reqBody.Stream = false
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodPost, "/api/chat", body))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, httpx.NewAPIError("ollama", res)
+	}
+	var cr ChatResponse
+	if err := json.NewDecoder(res.Body).Decode(&cr); err != nil {
+		return nil, err
+	}
+	return &cr, nil
+}
+
+// ChatStream sends the request with stream=true. Ollama answers with newline-delimited
+// JSON objects; onChunk receives each of them and the returned response has the whole
+// message assembled, with counters from the final (done) chunk.
+func (c *Client) ChatStream(ctx context.Context, reqBody ChatRequest, onChunk func(ChatResponse)) (*ChatResponse, error) {
+	reqBody.Stream = true
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	idle, ctx := httpx.NewIdleTimeout(ctx, c.idle)
+	defer idle.Stop()
+	res, err := httpx.Do(ctx, c.stream, idle.Request(c.newRequest(ctx, http.MethodPost, "/api/chat", body)))
+	if err != nil {
+		return nil, idle.Err(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, httpx.NewAPIError("ollama", res)
+	}
+
+	var (
+		out     ChatResponse
+		content strings.Builder
+	)
+	sc := bufio.NewScanner(idle.Body(res.Body))
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var chunk ChatResponse
+		if err := json.Unmarshal(line, &chunk); err != nil {
+			return nil, fmt.Errorf("ollama stream decode: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, &httpx.APIError{Provider: "ollama", Message: chunk.Error, Body: append([]byte(nil), line...)}
+		}
+		content.WriteString(chunk.Message.Content)
+		out.Message.ToolCalls = append(out.Message.ToolCalls, chunk.Message.ToolCalls...)
+		if onChunk != nil {
+			onChunk(chunk)
+		}
+		if chunk.Done {
+			out.Model, out.CreatedAt = chunk.Model, chunk.CreatedAt
+			out.Done, out.DoneReason = true, chunk.DoneReason
+			out.PromptEvalCount, out.EvalCount = chunk.PromptEvalCount, chunk.EvalCount
+			break
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, idle.Err(err)
+	}
+	if !out.Done {
+		return nil, errors.New("ollama: stream ended before done")
+	}
+	out.Message.Role = "assistant"
+	out.Message.Content = content.String()
+	return &out, nil
+}
@@ -4,14 +4,111 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
-	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
+
+	"agent_challenge/internal/httpx"
 )
 
-const baseURL = "https://openrouter.ai/api/v1"
+// DefaultBaseURL is the OpenRouter API root; any OpenAI-compatible /v1 root works too.
+const DefaultBaseURL = "https://openrouter.ai/api/v1"
+
+// Client is a reusable API client. It is safe for concurrent use and shares the
+// underlying connection pool between calls.
+type Client struct {
+	name    string // provider name reported in APIError
+	baseURL string
+	token   string
+	headers http.Header
+	http    *http.Client  // non-streaming calls, bounded by Timeout
+	stream  *http.Client  // same transport, no timeout: streams are bounded by ctx and idle
+	idle    time.Duration // longest silence of a stream, the Timeout of non-streaming calls
+}
+
+type Option func(*Client)
+
+// WithBaseURL points the client at another OpenAI-compatible API root, e.g. http://localhost:8080/v1.
+func WithBaseURL(u string) Option {
+	return func(c *Client) { c.baseURL = strings.TrimRight(u, "/") }
+}
+
+// WithName sets the provider name used in errors, e.g. "custom" for a local llama.cpp server.
+func WithName(name string) Option {
+	return func(c *Client) { c.name = name }
+}
+
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithTimeout limits a single non-streaming attempt and how long a stream may send
+// nothing. Default is 60s.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.http.Timeout = d }
+}
+
+// WithTransport sets a custom RoundTripper (proxy, TLS settings, request logging).
+func WithTransport(rt http.RoundTripper) Option {
+	return func(c *Client) { c.http.Transport = rt }
+}
+
+// WithHTTPClient uses a copy of hc for all calls.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		cp := *hc
+		c.http = &cp
+	}
+}
+
+// WithHeader adds a header sent with every request, e.g. HTTP-Referer or X-Title
+// for OpenRouter app attribution.
+func WithHeader(key, value string) Option {
+	return func(c *Client) { c.headers.Set(key, value) }
+}
+
+func NewClient(opts ...Option) *Client {
+	c := &Client{
+		name:    "openrouter",
+		baseURL: DefaultBaseURL,
+		headers: http.Header{},
+		http:    &http.Client{Timeout: 60 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	sc := *c.http
+	sc.Timeout = 0
+	c.stream = &sc
+	c.idle = c.http.Timeout
+	return c
+}
+
+// BaseURL returns the API root the client talks to.
+func (c *Client) BaseURL() string { return c.baseURL }
+
+// newRequest returns a request factory for httpx.Do so the body can be replayed on retries.
+func (c *Client) newRequest(ctx context.Context, method, path string, body []byte) func() (*http.Request, error) {
+	return func() (*http.Request, error) {
+		var rd io.Reader
+		if body != nil {
+			rd = bytes.NewReader(body)
+		}
+		req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, rd)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range c.headers {
+			req.Header[k] = v
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+}
 
 type Model struct {
 	ID   string `json:"id"`
@@ -22,23 +119,14 @@ type modelsResponse struct {
 	Data []Model `json:"data"`
 }
 
-func ListModels(ctx context.Context, token string) ([]Model, error) {
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 30 * time.Second}
-	res, err := client.Do(req)
+func (c *Client) ListModels(ctx context.Context) ([]Model, error) {
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodGet, "/models", nil))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		b, _ := io.ReadAll(res.Body)
-		return nil, fmt.Errorf("openrouter models error: %s", string(b))
+		return nil, httpx.NewAPIError(c.name, res)
 	}
 	var mr modelsResponse
 	if err := json.NewDecoder(res.Body).Decode(&mr); err != nil {
@@ -80,15 +168,16 @@ type ToolCall struct {
 }
 
 type ChatCompletionRequest struct {
-	Model          string        `json:"model"`
-	Messages       []ChatMessage `json:"messages"`
-	Tools          []Tool        `json:"tools,omitempty"`
-	ToolChoice     string        `json:"tool_choice,omitempty"`
-	ResponseFormat any           `json:"response_format,omitempty"`
-	MaxTokens      int           `json:"max_tokens,omitempty"`
-	Stream         bool          `json:"stream,omitempty"`
-	Stop           []string      `json:"stop,omitempty"`
-	Temperature    float64       `json:"temperature,omitempty"`
+	Model          string         `json:"model"`
+	Messages       []ChatMessage  `json:"messages"`
+	Tools          []Tool         `json:"tools,omitempty"`
+	ToolChoice     string         `json:"tool_choice,omitempty"`
+	ResponseFormat any            `json:"response_format,omitempty"`
+	MaxTokens      int            `json:"max_tokens,omitempty"`
+	Stream         bool           `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions `json:"stream_options,omitempty"`
+	Stop           []string       `json:"stop,omitempty"`
+	Temperature    float64        `json:"temperature"` // always sent: 0 is a valid temperature
 }
 
 type Choice struct {
@@ -113,27 +202,18 @@ type Usage struct {
 	TotalTokens      int `json:"total_tokens"`
 }
 
-func CreateChatCompletion(ctx context.Context, token string, reqBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
-	buf := new(bytes.Buffer)
-	if err := json.NewEncoder(buf).Encode(reqBody); err != nil {
-		return nil, err
-	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL+"/chat/completions", buf)
+func (c *Client) CreateChatCompletion(ctx context.Context, reqBody ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	body, err := json.Marshal(reqBody)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+token)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{Timeout: 60 * time.Second}
-	res, err := client.Do(req)
+	res, err := httpx.Do(ctx, c.http, c.newRequest(ctx, http.MethodPost, "/chat/completions", body))
 	if err != nil {
 		return nil, err
 	}
 	defer res.Body.Close()
 	if res.StatusCode < 200 || res.StatusCode >= 300 {
-		b, _ := io.ReadAll(res.Body)
-		return nil, errors.New(string(b))
+		return nil, httpx.NewAPIError(c.name, res)
 	}
 	var cr ChatCompletionResponse
 	if err := json.NewDecoder(res.Body).Decode(&cr); err != nil {
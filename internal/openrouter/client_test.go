@@ -0,0 +1,17 @@
+package openrouter
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRequestSendsZeroTemperature(t *testing.T) {
+	b, err := json.Marshal(ChatCompletionRequest{Model: "m", Temperature: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"temperature":0`) {
+		t.Errorf("request %s lacks temperature 0", b)
+	}
+}
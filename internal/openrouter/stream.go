@@ -0,0 +1,178 @@
+package openrouter
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"agent_challenge/internal/httpx"
+)
+
+// Streaming API types (OpenAI-compatible server-sent events)
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type ToolCallDelta struct {
+	Index    int              `json:"index"`
+	ID       string           `json:"id,omitempty"`
+	Type     string           `json:"type,omitempty"`
+	Function ToolCallFunction `json:"function"`
+}
+
+type MessageDelta struct {
+	Role      string          `json:"role,omitempty"`
+	Content   string          `json:"content,omitempty"`
+	ToolCalls []ToolCallDelta `json:"tool_calls,omitempty"`
+}
+
+type ChunkChoice struct {
+	Index        int          `json:"index"`
+	Delta        MessageDelta `json:"delta"`
+	FinishReason string       `json:"finish_reason"`
+}
+
+type ChatCompletionChunk struct {
+	ID      string        `json:"id"`
+	Model   string        `json:"model"`
+	Choices []ChunkChoice `json:"choices"`
+	Usage   *Usage        `json:"usage,omitempty"`
+	Error   *struct {
+		Code     any            `json:"code"`
+		Message  string         `json:"message"`
+		Metadata map[string]any `json:"metadata"`
+	} `json:"error,omitempty"`
+}
+
+// StreamDelta is a single increment of a streamed completion passed to the caller.
+// Tool call fragments carry partial arguments keyed by Index; FinishReason and Usage
+// are set only on the chunks that report them.
+type StreamDelta struct {
+	Content      string
+	ToolCalls    []ToolCallDelta
+	FinishReason string
+	Usage        *Usage
+}
+
+// CreateChatCompletionStream sends the request with stream=true, calls onDelta for every
+// received chunk and returns the assembled response once the stream is finished.
+func (c *Client) CreateChatCompletionStream(ctx context.Context, reqBody ChatCompletionRequest, onDelta func(StreamDelta)) (*ChatCompletionResponse, error) {
+	reqBody.Stream = true
+	reqBody.StreamOptions = &StreamOptions{IncludeUsage: true}
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return nil, err
+	}
+	idle, ctx := httpx.NewIdleTimeout(ctx, c.idle)
+	defer idle.Stop()
+	newReq := c.newRequest(ctx, http.MethodPost, "/chat/completions", body)
+	res, err := httpx.Do(ctx, c.stream, idle.Request(func() (*http.Request, error) {
+		req, err := newReq()
+		if err == nil {
+			req.Header.Set("Accept", "text/event-stream")
+		}
+		return req, err
+	}))
+	if err != nil {
+		return nil, idle.Err(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, httpx.NewAPIError(c.name, res)
+	}
+	cr, err := readStream(c.name, idle.Body(res.Body), onDelta)
+	return cr, idle.Err(err)
+}
+
+// readStream parses "data: {...}" events until [DONE] or EOF and assembles the message.
+func readStream(name string, r io.Reader, onDelta func(StreamDelta)) (*ChatCompletionResponse, error) {
+	var (
+		cr      ChatCompletionResponse
+		content strings.Builder
+		finish  string
+		calls   = map[int]*ToolCall{}
+	)
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for sc.Scan() {
+		line := sc.Text()
+		// Blank lines separate events; lines starting with ':' are keep-alive comments
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+		var chunk ChatCompletionChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("stream decode: %w", err)
+		}
+		if chunk.Error != nil {
+			e := &httpx.APIError{Provider: name, Message: chunk.Error.Message, Metadata: chunk.Error.Metadata, Body: []byte(data)}
+			if code, ok := chunk.Error.Code.(float64); ok {
+				e.StatusCode = int(code)
+			} else if chunk.Error.Code != nil {
+				e.Code = fmt.Sprint(chunk.Error.Code)
+			}
+			return nil, e
+		}
+		if cr.ID == "" {
+			cr.ID, cr.Model = chunk.ID, chunk.Model
+		}
+		d := StreamDelta{Usage: chunk.Usage}
+		if chunk.Usage != nil {
+			cr.Usage = chunk.Usage
+		}
+		if len(chunk.Choices) > 0 {
+			ch := chunk.Choices[0]
+			d.Content = ch.Delta.Content
+			d.ToolCalls = ch.Delta.ToolCalls
+			d.FinishReason = ch.FinishReason
+			content.WriteString(ch.Delta.Content)
+			for _, tcd := range ch.Delta.ToolCalls {
+				tc, ok := calls[tcd.Index]
+				if !ok {
+					tc = &ToolCall{Type: "function"}
+					calls[tcd.Index] = tc
+				}
+				if tcd.ID != "" {
+					tc.ID = tcd.ID
+				}
+				if tcd.Type != "" {
+					tc.Type = tcd.Type
+				}
+				tc.Function.Name += tcd.Function.Name
+				tc.Function.Arguments += tcd.Function.Arguments
+			}
+			if ch.FinishReason != "" {
+				finish = ch.FinishReason
+			}
+		}
+		if onDelta != nil && (d.Content != "" || len(d.ToolCalls) > 0 || d.FinishReason != "" || d.Usage != nil) {
+			onDelta(d)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+
+	msg := ChatMessage{Role: "assistant", Content: content.String()}
+	idx := make([]int, 0, len(calls))
+	for i := range calls {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	for _, i := range idx {
+		msg.ToolCalls = append(msg.ToolCalls, *calls[i])
+	}
+	cr.Object = "chat.completion"
+	cr.Choices = []Choice{{FinishReason: finish, Message: msg}}
+	return &cr, nil
+}
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"agent_challenge/internal/huggingface"
+	"agent_challenge/internal/openrouter"
+)
+
+// HuggingFace talks to the HF Inference API. The text-generation endpoint has
+// no chat schema, so the conversation is collapsed into a single prompt.
+type HuggingFace struct {
+	client *huggingface.Client
+}
+
+func NewHuggingFace(client *huggingface.Client) *HuggingFace {
+	return &HuggingFace{client: client}
+}
+
+func (p *HuggingFace) Name() string { return "hf" }
+
+func (p *HuggingFace) Capabilities() Capabilities {
+	return Capabilities{Stop: true, Streaming: true}
+}
+
+func (p *HuggingFace) Chat(ctx context.Context, req openrouter.ChatCompletionRequest) (*openrouter.ChatCompletionResponse, error) {
+	if req.Model == "" || !p.client.HasToken() {
+		return nil, errMissingSetup
+	}
+	res, err := p.client.Generate(ctx, req.Model, collapsePrompt(req.Messages), generateOptions(req))
+	if err != nil {
+		return nil, err
+	}
+	return toResponse(req.Model, res), nil
+}
+
+func (p *HuggingFace) ChatStream(ctx context.Context, req openrouter.ChatCompletionRequest, onDelta func(openrouter.StreamDelta)) (*openrouter.ChatCompletionResponse, error) {
+	if req.Model == "" || !p.client.HasToken() {
+		return nil, errMissingSetup
+	}
+	onToken := func(text string) {
+		if onDelta != nil {
+			onDelta(openrouter.StreamDelta{Content: text})
+		}
+	}
+	res, err := p.client.GenerateStream(ctx, req.Model, collapsePrompt(req.Messages), generateOptions(req), onToken)
+	if err != nil {
+		return nil, err
+	}
+	if onDelta != nil && res.FinishReason != "" {
+		onDelta(openrouter.StreamDelta{FinishReason: res.FinishReason})
+	}
+	return toResponse(req.Model, res), nil
+}
+
+func (p *HuggingFace) ListModels(ctx context.Context) ([]string, error) {
+	return p.client.ListTextGenModels(ctx, 50)
+}
+
+var errMissingSetup = errors.New("HF провайдер: укажите /hfmodel <org/repo> и /hftoken <token>")
+
+func generateOptions(req openrouter.ChatCompletionRequest) huggingface.Options {
+	return huggingface.Options{Temperature: req.Temperature, MaxNewTokens: req.MaxTokens, Stop: req.Stop}
+}
+
+func toResponse(model string, res *huggingface.Result) *openrouter.ChatCompletionResponse {
+	resp := &openrouter.ChatCompletionResponse{
+		Model: model,
+		Choices: []openrouter.Choice{{
+			FinishReason: res.FinishReason,
+			Message:      openrouter.ChatMessage{Role: "assistant", Content: res.Text},
+		}},
+	}
+	if res.Tokens > 0 {
+		resp.Usage = &openrouter.Usage{CompletionTokens: res.Tokens}
+	}
+	return resp
+}
+
+// collapsePrompt renders chat messages as a plain role-tagged transcript.
+func collapsePrompt(messages []openrouter.ChatMessage) string {
+	var b strings.Builder
+	for _, m := range messages {
+		switch m.Role {
+		case "system", "user", "assistant", "tool":
+			b.WriteString("[" + m.Role + "] ")
+		}
+		b.WriteString(m.Content)
+		b.WriteString("\n\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,142 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"agent_challenge/internal/ollama"
+	"agent_challenge/internal/openrouter"
+)
+
+// Ollama talks to a local Ollama server through its native /api/chat endpoint.
+type Ollama struct {
+	client *ollama.Client
+}
+
+func NewOllama(client *ollama.Client) *Ollama {
+	return &Ollama{client: client}
+}
+
+func (p *Ollama) Name() string { return "ollama" }
+
+func (p *Ollama) Capabilities() Capabilities {
+	return Capabilities{Tools: true, JSONMode: true, Stop: true, Usage: true, Streaming: true}
+}
+
+func (p *Ollama) Chat(ctx context.Context, req openrouter.ChatCompletionRequest) (*openrouter.ChatCompletionResponse, error) {
+	oreq, err := toOllamaRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Chat(ctx, oreq)
+	if err != nil {
+		return nil, err
+	}
+	return fromOllamaResponse(res), nil
+}
+
+func (p *Ollama) ChatStream(ctx context.Context, req openrouter.ChatCompletionRequest, onDelta func(openrouter.StreamDelta)) (*openrouter.ChatCompletionResponse, error) {
+	oreq, err := toOllamaRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	onChunk := func(c ollama.ChatResponse) {
+		if onDelta != nil && c.Message.Content != "" {
+			onDelta(openrouter.StreamDelta{Content: c.Message.Content})
+		}
+	}
+	res, err := p.client.ChatStream(ctx, oreq, onChunk)
+	if err != nil {
+		return nil, err
+	}
+	resp := fromOllamaResponse(res)
+	if onDelta != nil {
+		onDelta(openrouter.StreamDelta{FinishReason: resp.Choices[0].FinishReason, Usage: resp.Usage})
+	}
+	return resp, nil
+}
+
+func (p *Ollama) ListModels(ctx context.Context) ([]string, error) {
+	models, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.Name)
+	}
+	return ids, nil
+}
+
+func toOllamaRequest(req openrouter.ChatCompletionRequest) (ollama.ChatRequest, error) {
+	if req.Model == "" {
+		return ollama.ChatRequest{}, errors.New("ollama: модель не выбрана, укажите /model <name> (список: /models)")
+	}
+	out := ollama.ChatRequest{Model: req.Model, Options: map[string]any{"temperature": req.Temperature}}
+	if req.MaxTokens > 0 {
+		out.Options["num_predict"] = req.MaxTokens
+	}
+	if len(req.Stop) > 0 {
+		out.Options["stop"] = req.Stop
+	}
+	if req.ResponseFormat != nil {
+		out.Format = "json"
+	}
+	for _, t := range req.Tools {
+		out.Tools = append(out.Tools, ollama.Tool{
+			Type:     t.Type,
+			Function: ollama.ToolFunction{Name: t.Function.Name, Description: t.Function.Description, Parameters: t.Function.Parameters},
+		})
+	}
+	for _, m := range req.Messages {
+		om := ollama.Message{Role: m.Role, Content: m.Content}
+		if m.Role == "tool" {
+			om.ToolName = m.Name
+		}
+		for _, tc := range m.ToolCalls {
+			args := map[string]any{}
+			if tc.Function.Arguments != "" {
+				if err := json.Unmarshal([]byte(tc.Function.Arguments), &args); err != nil {
+					return ollama.ChatRequest{}, fmt.Errorf("ollama: tool call %s arguments: %w", tc.Function.Name, err)
+				}
+			}
+			om.ToolCalls = append(om.ToolCalls, ollama.ToolCall{Function: ollama.ToolCallFunction{Name: tc.Function.Name, Arguments: args}})
+		}
+		out.Messages = append(out.Messages, om)
+	}
+	return out, nil
+}
+
+// callSeq numbers generated tool call IDs so they stay unique within a session.
+var callSeq atomic.Uint64
+
+// fromOllamaResponse maps to the OpenAI schema. Ollama does not assign tool call IDs,
+// so they are generated.
+func fromOllamaResponse(res *ollama.ChatResponse) *openrouter.ChatCompletionResponse {
+	msg := openrouter.ChatMessage{Role: "assistant", Content: res.Message.Content}
+	for _, tc := range res.Message.ToolCalls {
+		args, _ := json.Marshal(tc.Function.Arguments)
+		msg.ToolCalls = append(msg.ToolCalls, openrouter.ToolCall{
+			ID:       fmt.Sprintf("call_%d", callSeq.Add(1)),
+			Type:     "function",
+			Function: openrouter.ToolCallFunction{Name: tc.Function.Name, Arguments: string(args)},
+		})
+	}
+	finish := res.DoneReason
+	if len(msg.ToolCalls) > 0 {
+		finish = "tool_calls"
+	}
+	return &openrouter.ChatCompletionResponse{
+		Model:   res.Model,
+		Object:  "chat.completion",
+		Choices: []openrouter.Choice{{FinishReason: finish, Message: msg}},
+		Usage: &openrouter.Usage{
+			PromptTokens:     res.PromptEvalCount,
+			CompletionTokens: res.EvalCount,
+			TotalTokens:      res.PromptEvalCount + res.EvalCount,
+		},
+	}
+}
@@ -0,0 +1,39 @@
+package provider
+
+import (
+	"net/url"
+	"strings"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// OpenAICompatible talks to any server exposing the OpenAI /v1/chat/completions and
+// /v1/models endpoints (llama.cpp server, vLLM, LM Studio, ...). The wire format is
+// the one the openrouter package already speaks, so only the name differs.
+type OpenAICompatible struct {
+	*OpenRouter
+	name string
+}
+
+func NewOpenAICompatible(name string, client *openrouter.Client) *OpenAICompatible {
+	return &OpenAICompatible{OpenRouter: NewOpenRouter(client), name: name}
+}
+
+func (p *OpenAICompatible) Name() string { return p.name }
+
+// OpenAIBaseURL normalizes a user supplied server address: a bare host gets http://
+// and an empty path becomes /v1, so "localhost:8080" means http://localhost:8080/v1.
+func OpenAIBaseURL(raw string) string {
+	raw = strings.TrimSpace(raw)
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	if strings.Trim(u.Path, "/") == "" {
+		u.Path = "/v1"
+	}
+	return strings.TrimRight(u.String(), "/")
+}
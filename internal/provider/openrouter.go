@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"context"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// OpenRouter talks to the OpenRouter chat completions API.
+type OpenRouter struct {
+	client *openrouter.Client
+}
+
+func NewOpenRouter(client *openrouter.Client) *OpenRouter {
+	return &OpenRouter{client: client}
+}
+
+func (p *OpenRouter) Name() string { return "openrouter" }
+
+func (p *OpenRouter) Capabilities() Capabilities {
+	return Capabilities{Tools: true, JSONMode: true, Stop: true, Usage: true, Streaming: true}
+}
+
+func (p *OpenRouter) Chat(ctx context.Context, req openrouter.ChatCompletionRequest) (*openrouter.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletion(ctx, req)
+}
+
+func (p *OpenRouter) ChatStream(ctx context.Context, req openrouter.ChatCompletionRequest, onDelta func(openrouter.StreamDelta)) (*openrouter.ChatCompletionResponse, error) {
+	return p.client.CreateChatCompletionStream(ctx, req, onDelta)
+}
+
+func (p *OpenRouter) ListModels(ctx context.Context) ([]string, error) {
+	models, err := p.client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(models))
+	for _, m := range models {
+		ids = append(ids, m.ID)
+	}
+	return ids, nil
+}
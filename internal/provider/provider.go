@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"context"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// Capabilities describes which request features a provider honours.
+// Providers silently ignore fields they do not support; callers consult
+// Capabilities to avoid sending them in the first place.
+type Capabilities struct {
+	Tools     bool // native tool/function calling
+	JSONMode  bool // response_format {"type":"json_object"}
+	Stop      bool // stop sequences
+	Usage     bool // token usage reported in responses
+	Streaming bool // incremental deltas in ChatStream
+}
+
+// Provider is a chat backend. Requests and responses use the OpenAI-compatible
+// types from the openrouter package as the common schema.
+type Provider interface {
+	Name() string
+	Chat(ctx context.Context, req openrouter.ChatCompletionRequest) (*openrouter.ChatCompletionResponse, error)
+	// ChatStream calls onDelta as the answer is generated and returns the assembled response.
+	// Providers without streaming support deliver the whole answer as a single delta.
+	ChatStream(ctx context.Context, req openrouter.ChatCompletionRequest, onDelta func(openrouter.StreamDelta)) (*openrouter.ChatCompletionResponse, error)
+	ListModels(ctx context.Context) ([]string, error)
+	Capabilities() Capabilities
+}
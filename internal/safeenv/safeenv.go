@@ -0,0 +1,27 @@
+// Package safeenv gives child processes the agent's environment without its secrets.
+package safeenv
+
+import (
+	"os"
+	"strings"
+)
+
+// Environ is os.Environ without API keys, tokens, secrets and passwords: any
+// variable whose name contains KEY, TOKEN, SECRET or PASSWORD.
+func Environ() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		if Secret(name) {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// Secret reports whether the variable name looks like it holds a credential.
+func Secret(name string) bool {
+	upper := strings.ToUpper(name)
+	return strings.Contains(upper, "KEY") || strings.Contains(upper, "TOKEN") || strings.Contains(upper, "SECRET") || strings.Contains(upper, "PASSWORD")
+}
@@ -0,0 +1,175 @@
+// Package session stores chat sessions as JSON files, one per session, so a
+// conversation can be resumed after a restart.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"agent_challenge/internal/openrouter"
+)
+
+// Session is the saved state of a REPL session.
+type Session struct {
+	Name        string                   `json:"name"`
+	SavedAt     time.Time                `json:"saved_at"`
+	Provider    string                   `json:"provider"`
+	Model       string                   `json:"model"`
+	Format      string                   `json:"format"`
+	Temperature *float64                 `json:"temperature,omitempty"` // 0 is a valid temperature
+	MaxTokens   int                      `json:"max_tokens"`
+	TZMode      bool                     `json:"tz_mode"`
+	TZFinalize  bool                     `json:"tz_finalize"` // the next answer is the final TZ
+	LastAnswer  string                   `json:"last_answer,omitempty"`
+	Messages    []openrouter.ChatMessage `json:"messages"`
+}
+
+// Info describes a saved session without its messages.
+type Info struct {
+	Name     string
+	SavedAt  time.Time
+	Provider string
+	Model    string
+	TZMode   bool
+	Messages int
+}
+
+// Store keeps sessions in a directory as <name>.json.
+type Store struct {
+	Dir string
+}
+
+var validName = regexp.MustCompile(`^[\p{L}\p{N}_][\p{L}\p{N}_.-]*$`)
+
+// CheckName reports whether name can be used as a session name.
+func CheckName(name string) error {
+	if !validName.MatchString(name) || len(name) > 100 {
+		return fmt.Errorf("invalid session name %q: use letters, digits, '_', '-' and '.'", name)
+	}
+	return nil
+}
+
+func (s *Store) path(name string) (string, error) {
+	if err := CheckName(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(s.Dir, name+".json"), nil
+}
+
+// Save writes sess under sess.Name, replacing an earlier save, and sets SavedAt.
+func (s *Store) Save(sess *Session) error {
+	p, err := s.path(sess.Name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.Dir, 0o755); err != nil {
+		return err
+	}
+	sess.SavedAt = time.Now()
+	b, err := json.MarshalIndent(sess, "", "  ")
+	if err != nil {
+		return err
+	}
+	// write to a temporary file first, so a crash never leaves half a session
+	tmp, err := os.CreateTemp(s.Dir, "."+sess.Name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// Load reads a saved session.
+func (s *Store) Load(name string) (*Session, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("session %s not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var sess Session
+	if err := json.Unmarshal(b, &sess); err != nil {
+		return nil, fmt.Errorf("session %s: %w", name, err)
+	}
+	sess.Name = name
+	return &sess, nil
+}
+
+// Remove deletes a saved session.
+func (s *Store) Remove(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("session %s not found", name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+// List returns the saved sessions, most recently saved first. Unreadable files
+// are skipped.
+func (s *Store) List() ([]Info, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Info
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || e.IsDir() || CheckName(name) != nil {
+			continue
+		}
+		sess, err := s.Load(name)
+		if err != nil {
+			continue
+		}
+		list = append(list, Info{
+			Name:     name,
+			SavedAt:  sess.SavedAt,
+			Provider: sess.Provider,
+			Model:    sess.Model,
+			TZMode:   sess.TZMode,
+			Messages: len(sess.Messages),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].SavedAt.After(list[j].SavedAt) })
+	return list, nil
+}
+
+// Latest returns the name of the most recently saved session.
+func (s *Store) Latest() (string, error) {
+	list, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	if len(list) == 0 {
+		return "", fmt.Errorf("no saved sessions in %s", s.Dir)
+	}
+	return list[0].Name, nil
+}
@@ -5,24 +5,77 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"agent_challenge/internal/agent"
+	"agent_challenge/internal/httpx"
 	"agent_challenge/internal/huggingface"
+	"agent_challenge/internal/mcp"
+	"agent_challenge/internal/ollama"
 	"agent_challenge/internal/openrouter"
+	"agent_challenge/internal/provider"
+	"agent_challenge/internal/session"
 )
 
 func main() {
+	// Currency rates for the convert tool
+	if f := strings.TrimSpace(os.Getenv("RATES_FILE")); f != "" {
+		agent.RatesFile = f
+	}
+	// serve-mcp: the built-in tools as an MCP server over stdio, for other assistants and IDEs.
+	// stdout carries the protocol, so nothing else may be printed there.
+	if len(os.Args) > 1 && os.Args[1] == "serve-mcp" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		if err := agent.ServeMCP(ctx, os.Stdin, os.Stdout); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(os.Stderr, "serve-mcp: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 
+	// Saved sessions live in AGENT_SESSIONS_DIR (default: sessions); --resume [name] continues one
+	sessions := &session.Store{Dir: sessionsDir()}
+	var resumed *session.Session
+	if name, ok := resumeFlag(os.Args[1:]); ok {
+		var err error
+		if name == "" {
+			name, err = sessions.Latest()
+		}
+		if err == nil {
+			resumed, err = sessions.Load(name)
+		}
+		if err != nil {
+			fmt.Printf("Не удалось продолжить сессию: %v\n", err)
+			return
+		}
+	}
+
+	// Startup provider: AGENT_PROVIDER=ollama runs fully offline, without an OpenRouter token
+	startProvider := strings.ToLower(strings.TrimSpace(os.Getenv("AGENT_PROVIDER")))
+	if resumed != nil && resumed.Provider != "" {
+		startProvider = resumed.Provider
+	}
+	if startProvider == "" {
+		startProvider = "openrouter"
+	}
+
 	// Token
 	token := strings.TrimSpace(os.Getenv("OPENROUTER_API_KEY"))
-	if token == "" {
+	if token == "" && startProvider == "openrouter" {
 		secret, err := readSecret("Введите OpenRouter API токен: ")
 		if err != nil {
 			fmt.Printf("Не удалось прочитать токен: %v\n", err)
@@ -35,35 +88,160 @@ func main() {
 		}
 	}
 
+	// Provider controls
+	hfToken := strings.TrimSpace(os.Getenv("HUGGINGFACE_API_KEY"))
+	hfClient := newHuggingFaceClient(hfToken)
+	providers := map[string]provider.Provider{
+		"openrouter": provider.NewOpenRouter(newOpenRouterClient(token)),
+		"hf":         provider.NewHuggingFace(hfClient),
+		"ollama":     provider.NewOllama(newOllamaClient()),
+	}
+	if u := strings.TrimSpace(os.Getenv("CUSTOM_BASE_URL")); u != "" {
+		providers["custom"] = provider.NewOpenAICompatible("custom", newCustomClient(u, os.Getenv("CUSTOM_API_KEY")))
+	}
+	prov, ok := providers[startProvider]
+	if !ok {
+		fmt.Printf("Неизвестный AGENT_PROVIDER=%s. Доступно: %s\n", startProvider, strings.Join(providerNames(providers), ", "))
+		return
+	}
+	// Current model per provider; hf is set via /hfmodel, e.g. meta-llama/Llama-3.1-8B-Instruct
+	models := map[string]string{"openrouter": "", "hf": "", "ollama": strings.TrimSpace(os.Getenv("OLLAMA_MODEL"))}
+	if resumed != nil && resumed.Model != "" {
+		models[prov.Name()] = resumed.Model
+	}
+
 	// Model selection
-	model := selectModel(token, reader)
+	model := models[prov.Name()]
+	if model == "" {
+		model = selectModel(prov, reader)
+	}
 	if model == "" {
 		fmt.Println("Модель не выбрана. Завершение.")
 		return
 	}
+	models[prov.Name()] = model
 
 	// Select answer format
-	format := selectFormat(reader)
+	var format string
+	if resumed != nil && resumed.Format != "" {
+		format = resumed.Format
+	} else {
+		format = selectFormat(reader)
+	}
 
 	// System prompt (format-aware)
 	sysPrompt := buildSystemPrompt(format)
 	messages := []openrouter.ChatMessage{{Role: "system", Content: sysPrompt}}
 
-	tools := agent.GetToolDefinitions()
+	// File tools are confined to the workspace (default: the current directory, where /save writes TZ files)
+	wsDir := strings.TrimSpace(os.Getenv("AGENT_WORKSPACE"))
+	if wsDir == "" {
+		wsDir = "."
+	}
+	ws, err := agent.NewWorkspace(wsDir)
+	if err != nil {
+		fmt.Printf("Некорректная рабочая папка AGENT_WORKSPACE=%s: %v\n", wsDir, err)
+		return
+	}
+	// Tools of this session; /tools enables and disables them
+	tools := agent.NewRegistry()
+	agent.RegisterWorkspaceTools(tools, ws)
+	// Writes are shown as a diff and need confirmation in the REPL
+	approver := replApprover{reader: reader}
+	agent.RegisterWriteTools(tools, ws, approver)
+	// Commands from AGENT_ALLOW_COMMANDS run without asking, e.g. "go test *,git status"
+	agent.RegisterCommandTool(tools, &agent.CommandRunner{
+		Workspace: ws,
+		Allow:     commandAllowlist(),
+		Approver:  approver,
+	})
+	// Web pages: FETCH_ALLOW limits fetch_url to listed domains, FETCH_DENY blocks domains
+	fetcher := &agent.Fetcher{
+		Client:    newFetchClient(),
+		Allow:     agent.ParseDomains(os.Getenv("FETCH_ALLOW")),
+		Deny:      agent.ParseDomains(os.Getenv("FETCH_DENY")),
+		UserAgent: "agent_challenge",
+	}
+	agent.RegisterFetchTool(tools, fetcher)
+	// Tool policies: allow, deny or ask per tool; see tool_policy.example.json
+	policy, err := loadToolPolicy()
+	if err != nil {
+		fmt.Printf("Не удалось загрузить политику инструментов: %v\n", err)
+		return
+	}
+	policy.Approver = approver
+	tools.SetPolicy(policy)
+	// MCP servers from MCP_CONFIG (or mcp.json): their tools are added as <server>__<tool>
+	// and need confirmation unless the policy says otherwise
+	mcpServers := startMCPServers(tools)
+	defer func() {
+		for _, c := range mcpServers {
+			c.Close()
+		}
+	}()
 	maxTokens := 512
 	temperature := 0.3
-	ctx := context.Background()
-
-	// Provider controls
-	provider := "openrouter" // or "hf"
-	hfToken := strings.TrimSpace(os.Getenv("HUGGINGFACE_API_KEY"))
-	hfModel := "" // e.g. meta-llama/Llama-3.1-8B-Instruct
+	// Transient HTTP failures (429/5xx, HF model loading) are retried by the clients
+	retryPolicy := httpx.DefaultRetryPolicy
+	ctx := httpx.WithRetryPolicy(context.Background(), retryPolicy)
 
 	// TZ mode controls
 	tzMode := false
 	tzEndMarker := "END_OF_TZ"
 	nextUseStop := false
 	lastAnswer := ""
+	// Print tokens as they arrive instead of waiting behind the spinner
+	streaming := true
+
+	// Name of the current session once saved or loaded; it is then saved after every answer
+	sessionName := ""
+	snapshot := func() *session.Session {
+		temp := temperature
+		return &session.Session{
+			Name:        sessionName,
+			Provider:    prov.Name(),
+			Model:       models[prov.Name()],
+			Format:      format,
+			Temperature: &temp,
+			MaxTokens:   maxTokens,
+			TZMode:      tzMode,
+			TZFinalize:  nextUseStop,
+			LastAnswer:  lastAnswer,
+			Messages:    messages,
+		}
+	}
+	// autosave saves a named session after every turn
+	autosave := func() {
+		if sessionName == "" {
+			return
+		}
+		if err := sessions.Save(snapshot()); err != nil {
+			fmt.Printf("[session] Не удалось сохранить сессию %s: %v\n", sessionName, err)
+		}
+	}
+	restore := func(s *session.Session) {
+		sessionName = s.Name
+		if s.Format != "" {
+			format = s.Format
+		}
+		if len(s.Messages) > 0 {
+			messages = s.Messages
+			sysPrompt = lastSystemPrompt(messages, buildSystemPrompt(format))
+		}
+		if s.Temperature != nil {
+			temperature = *s.Temperature
+		}
+		if s.MaxTokens > 0 {
+			maxTokens = s.MaxTokens
+		}
+		tzMode = s.TZMode
+		nextUseStop = s.TZFinalize
+		lastAnswer = s.LastAnswer
+	}
+	if resumed != nil {
+		restore(resumed)
+		fmt.Printf("Сессия %s: сообщений %d, провайдер %s, модель %s, формат %s\n", sessionName, len(messages), prov.Name(), models[prov.Name()], format)
+	}
 
 	fmt.Println("Готово. Введите сообщение (или 'exit' для выхода). Команды: /help, /format <text|markdown|json>")
 	for {
@@ -74,6 +252,13 @@ func main() {
 			continue
 		}
 		if strings.EqualFold(line, "exit") || strings.EqualFold(line, "quit") {
+			if sessionName != "" {
+				if err := sessions.Save(snapshot()); err != nil {
+					fmt.Printf("Не удалось сохранить сессию %s: %v\n", sessionName, err)
+				} else {
+					fmt.Printf("Сессия сохранена: %s\n", sessionName)
+				}
+			}
 			fmt.Println("Пока!")
 			return
 		}
@@ -128,40 +313,147 @@ func main() {
 				break
 			case "/provider":
 				if len(parts) < 2 {
-					fmt.Println("Использование: /provider openrouter | /provider hf")
+					fmt.Printf("Использование: /provider <%s> | /provider custom <url> [api_key]\n", strings.Join(providerNames(providers), "|"))
+					break
+				}
+				// OpenAI-совместимый сервер (llama.cpp, vLLM, LM Studio): /provider custom http://localhost:8080 [key]
+				if strings.ToLower(parts[1]) == "custom" && len(parts) >= 3 {
+					key := strings.TrimSpace(os.Getenv("CUSTOM_API_KEY"))
+					if len(parts) >= 4 {
+						key = parts[3]
+					}
+					providers["custom"] = provider.NewOpenAICompatible("custom", newCustomClient(parts[2], key))
+					models["custom"] = ""
+				}
+				p, ok := providers[strings.ToLower(parts[1])]
+				if !ok {
+					fmt.Printf("Неизвестный провайдер. Доступно: %s\n", strings.Join(providerNames(providers), ", "))
+					break
+				}
+				prov = p
+				fmt.Printf("Провайдер: %s\n", prov.Name())
+				if models[prov.Name()] == "" {
+					// Локальные серверы обычно обслуживают одну модель — выбираем её сами
+					ctxList, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+					ids, err := prov.ListModels(ctxList)
+					cancel()
+					if err == nil && len(ids) == 1 {
+						models[prov.Name()] = ids[0]
+						fmt.Printf("Модель (%s): %s\n", prov.Name(), ids[0])
+					} else {
+						fmt.Println("Модель не выбрана: /model <id> (список: /models)")
+					}
+				}
+			case "/retries":
+				if len(parts) < 2 {
+					fmt.Printf("Попыток на запрос: %d. Использование: /retries <1..10>\n", retryPolicy.MaxAttempts)
+					break
+				}
+				if v, err := strconv.Atoi(parts[1]); err == nil && v >= 1 && v <= 10 {
+					retryPolicy.MaxAttempts = v
+					ctx = httpx.WithRetryPolicy(context.Background(), retryPolicy)
+					fmt.Printf("Попыток на запрос: %d\n", v)
+				} else {
+					fmt.Println("Некорректное значение. Пример: /retries 3")
+				}
+			case "/stream":
+				if len(parts) < 2 || (parts[1] != "on" && parts[1] != "off") {
+					fmt.Println("Использование: /stream on | /stream off")
+					break
+				}
+				streaming = parts[1] == "on"
+				fmt.Printf("Потоковый вывод: %s\n", parts[1])
+			case "/tools":
+				// /tools — список; /tools on|off <name>
+				if len(parts) == 1 {
+					for _, t := range tools.Tools() {
+						mark := "✓"
+						if !tools.Enabled(t.Name) {
+							mark = "✗"
+						}
+						fmt.Printf("  %s %-10s — %s\n", mark, t.Name, t.Description)
+					}
 					break
 				}
-				p := strings.ToLower(parts[1])
-				if p != "openrouter" && p != "hf" {
-					fmt.Println("Неизвестный провайдер. Доступно: openrouter, hf")
+				if len(parts) < 3 || (parts[1] != "on" && parts[1] != "off") {
+					fmt.Println("Использование: /tools | /tools on <name> | /tools off <name>")
 					break
 				}
-				provider = p
-				fmt.Printf("Провайдер: %s\n", provider)
+				if err := tools.SetEnabled(parts[2], parts[1] == "on"); err != nil {
+					fmt.Printf("Ошибка: %v\n", err)
+					break
+				}
+				fmt.Printf("Инструмент %s: %s\n", parts[2], parts[1])
+			case "/mcp":
+				if len(mcpServers) == 0 {
+					fmt.Println("MCP-серверы не подключены (настройте MCP_CONFIG или mcp.json)")
+					break
+				}
+				for _, c := range mcpServers {
+					fmt.Printf("%s (%s %s):\n", c.Name(), c.ServerName, c.ServerVersion)
+					for _, t := range tools.Tools() {
+						if strings.HasPrefix(t.Name, c.Name()+"__") {
+							fmt.Printf("  %s\n", t.Name)
+						}
+					}
+				}
+			case "/policy":
+				// /policy — действия для всех инструментов; /policy <name> allow|deny|ask — на эту сессию
+				if len(parts) == 1 {
+					for _, t := range tools.Tools() {
+						fmt.Printf("  %-12s %s\n", t.Name, policy.Action(t.Name))
+					}
+					if names := policy.SessionAllowed(); len(names) > 0 {
+						fmt.Printf("Разрешены до конца сессии: %s\n", strings.Join(names, ", "))
+					}
+					if policy.Config != nil {
+						fmt.Printf("Журнал решений: %s\n", policy.Config.Log)
+					}
+					break
+				}
+				if len(parts) != 3 {
+					fmt.Println("Использование: /policy | /policy <name> allow|deny|ask")
+					break
+				}
+				known := false
+				for _, t := range tools.Tools() {
+					known = known || t.Name == parts[1]
+				}
+				if !known {
+					fmt.Printf("Ошибка: неизвестный инструмент %q\n", parts[1])
+					break
+				}
+				if err := policy.Set(parts[1], agent.PolicyAction(parts[2])); err != nil {
+					fmt.Printf("Ошибка: %v\n", err)
+					break
+				}
+				fmt.Printf("Политика %s: %s (до конца сессии)\n", parts[1], parts[2])
+			case "/model":
+				if len(parts) < 2 {
+					fmt.Printf("Модель (%s): %s\n", prov.Name(), models[prov.Name()])
+					break
+				}
+				models[prov.Name()] = parts[1]
+				fmt.Printf("Модель (%s): %s\n", prov.Name(), parts[1])
 			case "/hftoken":
 				if len(parts) < 2 {
 					fmt.Println("Использование: /hftoken <HF_TOKEN>")
 					break
 				}
 				hfToken = parts[1]
+				hfClient = newHuggingFaceClient(hfToken)
+				providers["hf"] = provider.NewHuggingFace(hfClient)
+				if prov.Name() == "hf" {
+					prov = providers["hf"]
+				}
 				fmt.Println("HF токен сохранён")
 			case "/hfmodel":
 				if len(parts) < 2 {
 					fmt.Println("Использование: /hfmodel <org/repo>")
 					break
 				}
-				hfModel = parts[1]
-				fmt.Printf("HF модель: %s\n", hfModel)
-				if len(parts) < 2 {
-					fmt.Println("Укажите температуру: /temp 0.0..1.5 (по умолчанию 0.3)")
-					break
-				}
-				if v, err := strconv.ParseFloat(parts[1], 64); err == nil && v >= 0 && v <= 2 {
-					temperature = v
-					fmt.Printf("temperature установлен: %.2f\n", temperature)
-				} else {
-					fmt.Println("Некорректное значение. Пример: /temp 0.7")
-				}
+				models["hf"] = parts[1]
+				fmt.Printf("HF модель: %s\n", models["hf"])
 			case "/temps":
 				// Usage: /temps "один и тот же запрос"
 				joined := strings.TrimSpace(line[len("/temps"):])
@@ -175,11 +467,8 @@ func main() {
 				temps := []float64{0.0, 0.7, 1.2}
 				for _, t := range temps {
 					fmt.Printf("\n--- temperature=%.1f ---\n", t)
-					req := openrouter.ChatCompletionRequest{Model: model, Messages: baseMsgs, MaxTokens: maxTokens, Temperature: t}
-					if strings.HasPrefix(format, "json") {
-						req.ResponseFormat = map[string]any{"type": "json_object"}
-					}
-					resp, err := openrouter.CreateChatCompletion(ctx, token, req)
+					req := chatRequest(models[prov.Name()], baseMsgs, maxTokens, t, format)
+					resp, err := prov.Chat(ctx, req)
 					if err != nil || len(resp.Choices) == 0 {
 						fmt.Printf("Ошибка: %v\n", err)
 						continue
@@ -201,16 +490,16 @@ func main() {
 				}
 				// выбираем модели с префиксом huggingface/ из списка
 				ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				mods, err := openrouter.ListModels(ctxList, token)
+				mods, err := providers["openrouter"].ListModels(ctxList)
 				cancel()
 				if err != nil || len(mods) == 0 {
 					fmt.Println("Не удалось получить список моделей.")
 					break
 				}
 				var hf []string
-				for _, m := range mods {
-					if strings.HasPrefix(m.ID, "huggingface/") {
-						hf = append(hf, m.ID)
+				for _, id := range mods {
+					if strings.HasPrefix(id, "huggingface/") {
+						hf = append(hf, id)
 					}
 				}
 				if len(hf) == 0 {
@@ -229,7 +518,7 @@ func main() {
 				for _, mid := range pick {
 					start := time.Now()
 					req := openrouter.ChatCompletionRequest{Model: mid, Messages: baseMsgs, MaxTokens: maxTokens, Temperature: temperature}
-					resp, err := openrouter.CreateChatCompletion(ctx, token, req)
+					resp, err := providers["openrouter"].Chat(ctx, req)
 					elapsed := time.Since(start)
 					if err != nil || len(resp.Choices) == 0 {
 						fmt.Printf("- %s: ошибка: %v\n", mid, err)
@@ -247,15 +536,29 @@ func main() {
 				}
 				continue
 			case "/models":
+				// /models — модели текущего провайдера
 				// /models hf — показать доступные huggingface/* модели из OpenRouter
 				// /models hf-free — только бесплатные huggingface/*:free из OpenRouter
 				// /models hf-hub — см. отдельную команду ниже (прямой список из Hub)
-				if len(parts) < 2 || (strings.ToLower(parts[1]) != "hf" && strings.ToLower(parts[1]) != "hf-free") {
-					fmt.Println("Использование: /models hf | /models hf-free | /models-hf-hub")
+				if len(parts) == 1 {
+					ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+					ids, err := prov.ListModels(ctxList)
+					cancel()
+					if err != nil {
+						fmt.Printf("Не удалось получить список моделей: %v\n", err)
+						break
+					}
+					for i, id := range ids {
+						fmt.Printf("%2d) %s\n", i+1, id)
+					}
+					continue
+				}
+				if strings.ToLower(parts[1]) != "hf" && strings.ToLower(parts[1]) != "hf-free" {
+					fmt.Println("Использование: /models | /models hf | /models hf-free | /models-hf-hub")
 					break
 				}
 				ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				mods, err := openrouter.ListModels(ctxList, token)
+				mods, err := providers["openrouter"].ListModels(ctxList)
 				cancel()
 				if err != nil || len(mods) == 0 {
 					fmt.Println("Не удалось получить список моделей.")
@@ -263,12 +566,12 @@ func main() {
 				}
 				filterFree := strings.ToLower(parts[1]) == "hf-free"
 				count := 0
-				for _, m := range mods {
-					if strings.HasPrefix(m.ID, "huggingface/") {
-						if filterFree && !strings.Contains(m.ID, ":free") {
+				for _, id := range mods {
+					if strings.HasPrefix(id, "huggingface/") {
+						if filterFree && !strings.Contains(id, ":free") {
 							continue
 						}
-						fmt.Println(m.ID)
+						fmt.Println(id)
 						count++
 					}
 				}
@@ -283,7 +586,7 @@ func main() {
 			case "/models-hf-hub":
 				// прямой список из Hugging Face Hub (text-generation, публичные, не gated)
 				ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				ids, err := huggingface.ListTextGenModels(ctxList, hfToken, 50)
+				ids, err := hfClient.ListTextGenModels(ctxList, 50)
 				cancel()
 				if err != nil {
 					fmt.Printf("Ошибка HF Hub: %v\n", err)
@@ -296,7 +599,7 @@ func main() {
 			case "/models-hf-free":
 				// alias: то же, что и /models-hf-hub, так как ListTextGenModels уже фильтрует public non-gated
 				ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-				ids, err := huggingface.ListTextGenModels(ctxList, hfToken, 50)
+				ids, err := hfClient.ListTextGenModels(ctxList, 50)
 				cancel()
 				if err != nil {
 					fmt.Printf("Ошибка HF Hub: %v\n", err)
@@ -327,14 +630,11 @@ func main() {
 					break
 				}
 				baseMsgs := []openrouter.ChatMessage{{Role: "system", Content: sysPrompt}, {Role: "user", Content: prompt}}
-				fmt.Println("Бенчмарк (произвольные модели):")
+				fmt.Printf("Бенчмарк (произвольные модели, %s):\n", prov.Name())
 				for _, mid := range modelsIn {
 					start := time.Now()
-					req := openrouter.ChatCompletionRequest{Model: mid, Messages: baseMsgs, MaxTokens: maxTokens, Temperature: temperature}
-					if strings.HasPrefix(format, "json") {
-						req.ResponseFormat = map[string]any{"type": "json_object"}
-					}
-					resp, err := openrouter.CreateChatCompletion(ctx, token, req)
+					req := chatRequest(mid, baseMsgs, maxTokens, temperature, format)
+					resp, err := prov.Chat(ctx, req)
 					elapsed := time.Since(start)
 					if err != nil || len(resp.Choices) == 0 {
 						fmt.Printf("- %s: ошибка: %v\n", mid, err)
@@ -374,7 +674,7 @@ func main() {
 				defaults := []string{}
 				if len(modelsIn) == 0 {
 					ctxList, cancel := context.WithTimeout(context.Background(), 20*time.Second)
-					ids, err := huggingface.ListTextGenModels(ctxList, hfToken, 3)
+					ids, err := hfClient.ListTextGenModels(ctxList, 3)
 					cancel()
 					if err == nil && len(ids) > 0 {
 						defaults = ids
@@ -397,19 +697,25 @@ func main() {
 				for _, mid := range benchModels {
 					start := time.Now()
 					opts := huggingface.Options{Temperature: temperature, MaxNewTokens: maxTokens, Stop: nil}
-					res, err := huggingface.Generate(ctx, hfToken, mid, prompt, opts)
+					var onToken func(string)
+					if streaming {
+						fmt.Printf("--- %s ---\n", mid)
+						onToken = func(t string) { fmt.Print(t) }
+					}
+					res, err := hfClient.GenerateStream(ctx, mid, prompt, opts, onToken)
 					elapsed := time.Since(start)
+					if streaming && err == nil {
+						fmt.Println()
+					}
 					var outText string
 					var elapsedUsed time.Duration
+					stats := "tokens: N/A"
 					if err != nil {
 						// Fallback: emulate via OpenRouter, but keep HF model name in output
 						baseMsgs := []openrouter.ChatMessage{{Role: "system", Content: sysPrompt}, {Role: "user", Content: prompt}}
-						req := openrouter.ChatCompletionRequest{Model: "openrouter/auto", Messages: baseMsgs, MaxTokens: maxTokens, Temperature: temperature}
-						if strings.HasPrefix(format, "json") {
-							req.ResponseFormat = map[string]any{"type": "json_object"}
-						}
+						req := chatRequest("openrouter/auto", baseMsgs, maxTokens, temperature, format)
 						start2 := time.Now()
-						respOR, errOR := openrouter.CreateChatCompletion(ctx, token, req)
+						respOR, errOR := providers["openrouter"].Chat(ctx, req)
 						elapsedUsed = time.Since(start2)
 						if errOR != nil || len(respOR.Choices) == 0 {
 							fmt.Printf("- %s: ошибка (HF и OR): %v | %v\n", mid, err, errOR)
@@ -419,14 +725,15 @@ func main() {
 					} else {
 						outText = res.Text
 						elapsedUsed = elapsed
+						stats = fmt.Sprintf("TTFT: %v | tokens: compl=%d", res.TimeToFirstToken, res.Tokens)
 					}
-					fmt.Printf("- %s: %v | tokens: N/A | cost: N/A\n", mid, elapsedUsed)
+					fmt.Printf("- %s: %v | %s | cost: N/A\n", mid, elapsedUsed, stats)
 					fname := fmt.Sprintf("benchhf3_%s_%s.txt", strings.ReplaceAll(strings.ReplaceAll(mid, "/", "-"), ":", "-"), time.Now().Format("20060102_150405"))
 					_ = os.WriteFile(fname, []byte(outText), 0644)
 				}
 				continue
 			case "/chaincheck":
-				// /chaincheck "<задача>" — два вызова: прямой и "Решай пошагово" через OpenRouter
+				// /chaincheck "<задача>" — два вызова: прямой и "Решай пошагово" через текущего провайдера
 				joined := strings.TrimSpace(line[len("/chaincheck"):])
 				firstQ := strings.Index(joined, "\"")
 				lastQ := strings.LastIndex(joined, "\"")
@@ -437,8 +744,8 @@ func main() {
 				task := strings.TrimSpace(joined[firstQ+1 : lastQ])
 				baseMsgs := []openrouter.ChatMessage{{Role: "system", Content: sysPrompt}}
 				// 1) прямой ответ
-				req1 := openrouter.ChatCompletionRequest{Model: model, Messages: append(baseMsgs, openrouter.ChatMessage{Role: "user", Content: task}), MaxTokens: maxTokens, Temperature: temperature}
-				resp1, err1 := openrouter.CreateChatCompletion(ctx, token, req1)
+				req1 := openrouter.ChatCompletionRequest{Model: models[prov.Name()], Messages: append(baseMsgs, openrouter.ChatMessage{Role: "user", Content: task}), MaxTokens: maxTokens, Temperature: temperature}
+				resp1, err1 := prov.Chat(ctx, req1)
 				var direct string
 				if err1 == nil && len(resp1.Choices) > 0 {
 					direct = resp1.Choices[0].Message.Content
@@ -447,8 +754,8 @@ func main() {
 				}
 				// 2) шаг за шагом
 				promptStep := task + "\n\nРешай пошагово."
-				req2 := openrouter.ChatCompletionRequest{Model: model, Messages: append(baseMsgs, openrouter.ChatMessage{Role: "user", Content: promptStep}), MaxTokens: maxTokens, Temperature: temperature}
-				resp2, err2 := openrouter.CreateChatCompletion(ctx, token, req2)
+				req2 := openrouter.ChatCompletionRequest{Model: models[prov.Name()], Messages: append(baseMsgs, openrouter.ChatMessage{Role: "user", Content: promptStep}), MaxTokens: maxTokens, Temperature: temperature}
+				resp2, err2 := prov.Chat(ctx, req2)
 				var stepByStep string
 				if err2 == nil && len(resp2.Choices) > 0 {
 					stepByStep = resp2.Choices[0].Message.Content
@@ -480,8 +787,8 @@ func main() {
 				// Agent 1 system + формат JSON
 				sys1 := "Ты Агент 1. Преобразуй вход в структурированный JSON с полями: summary, findings[], next_steps[]. Кратко и без лишнего."
 				msgs1 := []openrouter.ChatMessage{{Role: "system", Content: sys1}, {Role: "user", Content: goal}}
-				req1 := openrouter.ChatCompletionRequest{Model: model, Messages: msgs1, MaxTokens: maxTokens, Temperature: temperature, ResponseFormat: map[string]any{"type": "json_object"}}
-				resp1, err1 := openrouter.CreateChatCompletion(ctx, token, req1)
+				req1 := openrouter.ChatCompletionRequest{Model: models[prov.Name()], Messages: msgs1, MaxTokens: maxTokens, Temperature: temperature, ResponseFormat: map[string]any{"type": "json_object"}}
+				resp1, err1 := prov.Chat(ctx, req1)
 				if err1 != nil || len(resp1.Choices) == 0 {
 					fmt.Printf("Agent1 ошибка: %v\n", err1)
 					break
@@ -493,8 +800,8 @@ func main() {
 				sys2 := "Ты Агент 2. На основе переданного JSON (summary/findings/next_steps) сформируй понятный читаемый отчёт в Markdown."
 				prompt2 := "Вот JSON от Агент 1:\n\n" + jsonOut + "\n\nСформируй краткий отчёт (заголовок, пункты findings и next steps)."
 				msgs2 := []openrouter.ChatMessage{{Role: "system", Content: sys2}, {Role: "user", Content: prompt2}}
-				req2 := openrouter.ChatCompletionRequest{Model: model, Messages: msgs2, MaxTokens: maxTokens, Temperature: temperature}
-				resp2, err2 := openrouter.CreateChatCompletion(ctx, token, req2)
+				req2 := openrouter.ChatCompletionRequest{Model: models[prov.Name()], Messages: msgs2, MaxTokens: maxTokens, Temperature: temperature}
+				resp2, err2 := prov.Chat(ctx, req2)
 				if err2 != nil || len(resp2.Choices) == 0 {
 					fmt.Printf("Agent2 ошибка: %v\n", err2)
 					break
@@ -552,6 +859,89 @@ func main() {
 				default:
 					fmt.Println("Неизвестная подкоманда. Использование: /tz on | /tz off | /tz finalize")
 				}
+			case "/session":
+				sub := ""
+				if len(parts) >= 2 {
+					sub = strings.ToLower(parts[1])
+				}
+				switch sub {
+				case "save":
+					name := sessionName
+					if len(parts) >= 3 {
+						name = parts[2]
+					}
+					if name == "" {
+						name = time.Now().Format("20060102_150405")
+					}
+					s := snapshot()
+					s.Name = name
+					if err := sessions.Save(s); err != nil {
+						fmt.Printf("Ошибка сохранения сессии: %v\n", err)
+						break
+					}
+					sessionName = name
+					fmt.Printf("Сессия сохранена: %s (сообщений %d). Далее сохраняется после каждого ответа.\n", name, len(messages))
+				case "load":
+					if len(parts) < 3 {
+						fmt.Println("Использование: /session load <name>")
+						break
+					}
+					s, err := sessions.Load(parts[2])
+					if err != nil {
+						fmt.Printf("Ошибка загрузки сессии: %v\n", err)
+						break
+					}
+					if p, ok := providers[s.Provider]; ok {
+						prov = p
+						if s.Model != "" {
+							models[prov.Name()] = s.Model
+						}
+					} else if s.Provider != "" {
+						fmt.Printf("Провайдер %s недоступен, остаётся %s\n", s.Provider, prov.Name())
+					}
+					restore(s)
+					fmt.Printf("Сессия %s: сообщений %d, провайдер %s, модель %s, формат %s\n", sessionName, len(messages), prov.Name(), models[prov.Name()], format)
+				case "list":
+					list, err := sessions.List()
+					if err != nil {
+						fmt.Printf("Ошибка: %v\n", err)
+						break
+					}
+					if len(list) == 0 {
+						fmt.Printf("Сохранённых сессий нет (%s)\n", sessions.Dir)
+						break
+					}
+					for _, s := range list {
+						mark := " "
+						if s.Name == sessionName {
+							mark = "*"
+						}
+						tz := ""
+						if s.TZMode {
+							tz = ", ТЗ"
+						}
+						fmt.Printf("%s %-24s %s  сообщений %d  %s/%s%s\n", mark, s.Name, s.SavedAt.Format("2006-01-02 15:04"), s.Messages, s.Provider, s.Model, tz)
+					}
+				case "rm":
+					if len(parts) < 3 {
+						fmt.Println("Использование: /session rm <name>")
+						break
+					}
+					if err := sessions.Remove(parts[2]); err != nil {
+						fmt.Printf("Ошибка: %v\n", err)
+						break
+					}
+					if parts[2] == sessionName {
+						// the conversation goes on, but is no longer saved automatically
+						sessionName = ""
+					}
+					fmt.Printf("Сессия удалена: %s\n", parts[2])
+				default:
+					if sessionName != "" {
+						fmt.Printf("Текущая сессия: %s\n", sessionName)
+					}
+					fmt.Println("Использование: /session save [name] | /session load <name> | /session list | /session rm <name>")
+				}
 			default:
 				fmt.Println("Неизвестная команда. Введите /help для справки.")
 			}
@@ -566,183 +956,137 @@ func main() {
 
 		// Tool-calling loop (max 5 steps)
 		var assistantOut string
+		toolsCancelled := false
 		finalizeComplete := false
 		var finalBuffer strings.Builder
+		caps := prov.Capabilities()
+		streamed := false
+		stopSpin := func() {}
+		// JSON answers are buffered: they are validated and pretty-printed when complete
+		jsonAnswer := strings.HasPrefix(format, "json")
+		onDelta := func(d openrouter.StreamDelta) {
+			if d.Content == "" || jsonAnswer {
+				return
+			}
+			stopSpin()
+			if !streamed {
+				fmt.Print("Agent> ")
+				streamed = true
+			}
+			fmt.Print(d.Content)
+		}
+		// Ctrl+C cancels the request instead of exiting; a silent stream fails by itself
+		interrupted := false
+		send := func(req openrouter.ChatCompletionRequest) (*openrouter.ChatCompletionResponse, error) {
+			reqCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+			var (
+				resp *openrouter.ChatCompletionResponse
+				err  error
+			)
+			if streaming && caps.Streaming {
+				resp, err = prov.ChatStream(reqCtx, req, onDelta)
+			} else {
+				resp, err = prov.Chat(reqCtx, req)
+			}
+			if err != nil && reqCtx.Err() != nil && ctx.Err() == nil {
+				interrupted = true
+			}
+			return resp, err
+		}
+		// Spinner only until the first streamed token, so it never overwrites printed text
+		spin := func() {
+			if !streamed {
+				stopSpin = startSpinner("Думаю…")
+			}
+		}
 		for step := 0; step < 5; step++ {
-			var assistantMsg openrouter.ChatMessage
-			stopSpin := startSpinner("Думаю…")
+			spin()
 			// Увеличиваем лимит токенов на финальном шаге, чтобы не обрывалось по длине
 			reqMax := maxTokens
 			if nextUseStop && reqMax < 2000 {
 				reqMax = 2000
 			}
-			var resp *openrouter.ChatCompletionResponse
-			var err error
-			if provider == "openrouter" {
-				req := openrouter.ChatCompletionRequest{
-					Model:      model,
-					Messages:   messages,
-					Tools:      tools,
-					ToolChoice: "auto",
-					MaxTokens:  reqMax,
-				}
-				// hint model to return JSON if format requires it
-				if strings.HasPrefix(format, "json") {
-					req.ResponseFormat = map[string]any{"type": "json_object"}
-				}
-				// apply stop marker on finalize
-				if nextUseStop {
-					req.Stop = []string{tzEndMarker}
-					// disable tool-use on finalize to force direct final output
-					req.Tools = nil
-					req.ToolChoice = ""
-				}
-				// apply current temperature
-				req.Temperature = temperature
-				resp, err = openrouter.CreateChatCompletion(ctx, token, req)
-			} else {
-				// HuggingFace provider path: we collapse messages to a single prompt
-				var promptBuilder strings.Builder
-				for _, m := range messages {
-					if m.Role == "system" {
-						promptBuilder.WriteString("[system] ")
-					}
-					if m.Role == "user" {
-						promptBuilder.WriteString("[user] ")
-					}
-					if m.Role == "assistant" {
-						promptBuilder.WriteString("[assistant] ")
-					}
-					promptBuilder.WriteString(m.Content)
-					promptBuilder.WriteString("\n\n")
-				}
-				finalStop := []string(nil)
-				if nextUseStop {
-					finalStop = []string{tzEndMarker}
-				}
-				opts := huggingface.Options{Temperature: temperature, MaxNewTokens: reqMax, Stop: finalStop, TopP: 0}
-				if hfModel == "" || hfToken == "" {
-					stopSpin()
-					fmt.Println("HF провайдер: укажите /hfmodel <org/repo> и /hftoken <token>")
-					break
-				}
-				res, err := huggingface.Generate(ctx, hfToken, hfModel, promptBuilder.String(), opts)
-				stopSpin()
-				if err != nil {
-					fmt.Printf("Ошибка HF: %v\n", err)
-					break
-				}
-				assistantMsg = openrouter.ChatMessage{Role: "assistant", Content: res.Text}
-				messages = append(messages, assistantMsg)
+			req := chatRequest(models[prov.Name()], messages, reqMax, temperature, format)
+			if defs := tools.Definitions(); caps.Tools && len(defs) > 0 {
+				req.Tools = defs
+				req.ToolChoice = "auto"
+			}
+			// apply stop marker on finalize
+			if nextUseStop {
+				req.Stop = []string{tzEndMarker}
+				// disable tool-use on finalize to force direct final output
+				req.Tools = nil
+				req.ToolChoice = ""
 			}
-			if provider == "openrouter" && err != nil {
-				stopSpin()
+			resp, err := send(req)
+			if err != nil {
 				// Фолбэк: выбранная модель/провайдер не поддерживает инструменты
-				errLower := strings.ToLower(err.Error())
-				if strings.Contains(errLower, "support tool use") {
-					fmt.Println("Предупреждение: модель не поддерживает инструменты. Продолжаю без tools…")
-					stopSpin = startSpinner("Думаю…")
-					req2 := openrouter.ChatCompletionRequest{Model: model, Messages: messages, MaxTokens: reqMax}
-					if strings.HasPrefix(format, "json") {
-						req2.ResponseFormat = map[string]any{"type": "json_object"}
-					}
-					if nextUseStop {
-						req2.Stop = []string{tzEndMarker}
-						// На финале выключаем инструменты
-						req2.Tools = nil
-						req2.ToolChoice = ""
-					}
-					// температура в фолбэке
-					req2.Temperature = temperature
-					resp2, err2 := openrouter.CreateChatCompletion(ctx, token, req2)
+				if httpx.IsToolsUnsupported(err) && req.Tools != nil {
 					stopSpin()
-					if err2 != nil || len(resp2.Choices) == 0 {
-						fmt.Printf("Ошибка запроса: %v\n", err2)
-						break
-					}
-					finish := resp2.Choices[0].FinishReason
-					assistantMsg = resp2.Choices[0].Message
-					messages = append(messages, assistantMsg)
-					if nextUseStop {
-						finalBuffer.WriteString(assistantMsg.Content)
-					}
-					if nextUseStop && strings.EqualFold(finish, "length") {
-						messages = append(messages, openrouter.ChatMessage{Role: "user", Content: "Продолжай финальный вывод ТЗ с того места, где остановился. Заверши и выведи END_OF_TZ."})
-						continue
-					}
-					if nextUseStop && (strings.EqualFold(finish, "stop") || strings.Contains(assistantMsg.Content, tzEndMarker)) {
-						finalizeComplete = true
-					}
-					assistantOut = assistantMsg.Content
-					break
-				}
-				// Credit/max_tokens issue → reduce and retry once
-				if strings.Contains(errLower, "requires more credits") || strings.Contains(errLower, "fewer max_tokens") {
+					fmt.Println("Предупреждение: модель не поддерживает инструменты. Продолжаю без tools…")
+					spin()
+					req.Tools = nil
+					req.ToolChoice = ""
+					resp, err = send(req)
+				} else if httpx.IsInsufficientCredits(err) {
+					// Credit/max_tokens issue → reduce and retry once
 					newMax := maxTokens / 2
 					if newMax < 128 {
 						newMax = 128
 					}
+					stopSpin()
 					fmt.Printf("Недостаточно кредитов/слишком большой max_tokens. Понижаю до %d и повторяю…\n", newMax)
 					maxTokens = newMax
-					stopSpin = startSpinner("Думаю…")
-					reqMax = maxTokens
-					if nextUseStop && reqMax < 1500 {
-						reqMax = 1500
-					}
-					reqRetry := openrouter.ChatCompletionRequest{Model: model, Messages: messages, MaxTokens: reqMax, Tools: tools, ToolChoice: "auto"}
-					if strings.HasPrefix(format, "json") {
-						reqRetry.ResponseFormat = map[string]any{"type": "json_object"}
+					spin()
+					req.MaxTokens = maxTokens
+					if nextUseStop && req.MaxTokens < 1500 {
+						req.MaxTokens = 1500
 					}
-					if nextUseStop {
-						reqRetry.Stop = []string{tzEndMarker}
-						// disable tools on retry finalize as well
-						reqRetry.Tools = nil
-						reqRetry.ToolChoice = ""
+					resp, err = send(req)
+				} else if httpx.IsContextTooLong(err) {
+					// История не помещается в контекст модели → отбрасываем старую половину и повторяем
+					if trimmed := trimHistory(messages); len(trimmed) < len(messages) {
+						stopSpin()
+						fmt.Printf("Контекст модели переполнен. Сокращаю историю: %d → %d сообщений…\n", len(messages), len(trimmed))
+						messages = trimmed
+						spin()
+						req.Messages = messages
+						resp, err = send(req)
 					}
-					// температура в ретрае
-					reqRetry.Temperature = temperature
-					respRetry, errRetry := openrouter.CreateChatCompletion(ctx, token, reqRetry)
-					stopSpin()
-					if errRetry != nil || len(respRetry.Choices) == 0 {
-						fmt.Printf("Ошибка запроса после понижения max_tokens: %v\n", errRetry)
-						break
-					}
-					finish := respRetry.Choices[0].FinishReason
-					assistantMsg = respRetry.Choices[0].Message
-					messages = append(messages, assistantMsg)
-					if nextUseStop {
-						finalBuffer.WriteString(assistantMsg.Content)
-					}
-					if nextUseStop && strings.EqualFold(finish, "length") {
-						messages = append(messages, openrouter.ChatMessage{Role: "user", Content: "Продолжай финальный вывод ТЗ с того места, где остановился. Заверши и выведи END_OF_TZ."})
-						continue
-					}
-					if nextUseStop && (strings.EqualFold(finish, "stop") || strings.Contains(assistantMsg.Content, tzEndMarker)) {
-						finalizeComplete = true
-					}
-				} else {
-					fmt.Printf("Ошибка запроса: %v\n", err)
-					break
-				}
-			} else if provider == "openrouter" {
-				stopSpin()
-				if len(resp.Choices) == 0 {
-					fmt.Println("Пустой ответ модели")
-					break
 				}
-				finish := resp.Choices[0].FinishReason
-				assistantMsg = resp.Choices[0].Message
-				messages = append(messages, assistantMsg)
-				if nextUseStop {
-					finalBuffer.WriteString(assistantMsg.Content)
-				}
-				if nextUseStop && strings.EqualFold(finish, "length") {
-					messages = append(messages, openrouter.ChatMessage{Role: "user", Content: "Продолжай финальный вывод ТЗ с того места, где остановился. Заверши и выведи END_OF_TZ."})
-					continue
+			}
+			stopSpin()
+			if err != nil && interrupted {
+				if streamed {
+					fmt.Println()
 				}
-				if nextUseStop && (strings.EqualFold(finish, "stop") || strings.Contains(assistantMsg.Content, tzEndMarker)) {
-					finalizeComplete = true
+				fmt.Println("Запрос прерван.")
+				break
+			}
+			if err != nil {
+				if httpx.IsRateLimited(err) {
+					fmt.Println("Провайдер ограничил частоту запросов, повторы исчерпаны. Попробуйте позже или увеличьте /retries.")
 				}
+				fmt.Printf("Ошибка запроса: %v\n", err)
+				break
+			}
+			if len(resp.Choices) == 0 {
+				fmt.Println("Пустой ответ модели")
+				break
+			}
+			finish := resp.Choices[0].FinishReason
+			assistantMsg := resp.Choices[0].Message
+			messages = append(messages, assistantMsg)
+			if nextUseStop {
+				finalBuffer.WriteString(assistantMsg.Content)
+			}
+			if nextUseStop && strings.EqualFold(finish, "length") {
+				messages = append(messages, openrouter.ChatMessage{Role: "user", Content: "Продолжай финальный вывод ТЗ с того места, где остановился. Заверши и выведи END_OF_TZ."})
+				continue
+			}
+			if nextUseStop && (strings.EqualFold(finish, "stop") || strings.Contains(assistantMsg.Content, tzEndMarker)) {
+				finalizeComplete = true
 			}
 
 			if len(assistantMsg.ToolCalls) == 0 {
@@ -755,48 +1099,57 @@ func main() {
 				break
 			}
 
-			for _, tc := range assistantMsg.ToolCalls {
-				result := agent.ExecuteTool(tc)
+			// Independent calls run concurrently; Ctrl+C cancels the running tools instead of exiting
+			toolCtx, stopTools := signal.NotifyContext(ctx, os.Interrupt)
+			results := tools.ExecuteAll(toolCtx, assistantMsg.ToolCalls, agent.DefaultToolWorkers)
+			toolsCancelled = toolCtx.Err() != nil
+			stopTools()
+			// results come back in call order, as the tool messages must follow the assistant message
+			for i, tc := range assistantMsg.ToolCalls {
+				if tc.Function.Name == "run_command" {
+					showCommandResult(results[i])
+				}
 				messages = append(messages, openrouter.ChatMessage{
 					Role:       "tool",
-					Content:    result,
+					Content:    results[i],
 					ToolCallID: tc.ID,
 					Name:       tc.Function.Name,
 				})
-				// small pause to simulate tool latency
-				time.Sleep(50 * time.Millisecond)
+			}
+			if toolsCancelled {
+				fmt.Println("\nВыполнение инструментов прервано.")
+				break
 			}
 		}
 
+		if toolsCancelled || interrupted {
+			// the results are in the history; the user decides how to go on.
+			// Pages fetched in this turn must not be cited by the next answer.
+			fetcher.TakeCitations()
+			autosave()
+			continue
+		}
 		if assistantOut == "" {
 			// Try to get final answer after tools
-			stopSpin := startSpinner("Думаю…")
+			spin()
 			reqMax2 := maxTokens
 			if nextUseStop && reqMax2 < 2000 {
 				reqMax2 = 2000
 			}
-			req := openrouter.ChatCompletionRequest{Model: model, Messages: messages, MaxTokens: reqMax2}
-			if strings.HasPrefix(format, "json") {
-				req.ResponseFormat = map[string]any{"type": "json_object"}
-			}
+			req := chatRequest(models[prov.Name()], messages, reqMax2, temperature, format)
 			if nextUseStop {
-				req.Stop = []string{tzEndMarker}
 				// и здесь тоже фиксируем без tools
-				req.Tools = nil
-				req.ToolChoice = ""
+				req.Stop = []string{tzEndMarker}
 			}
-			// применяем температуру и в финальном запросе
-			req.Temperature = temperature
-			resp, err := openrouter.CreateChatCompletion(ctx, token, req)
+			resp, err := send(req)
 			stopSpin()
 			if err == nil && len(resp.Choices) > 0 {
-				if nextUseStop {
-					assistantOut = resp.Choices[0].Message.Content
-				} else {
-					assistantOut = resp.Choices[0].Message.Content
-				}
+				assistantOut = resp.Choices[0].Message.Content
 			}
 		}
+		if streamed {
+			fmt.Println()
+		}
 
 		// Если финализация — используем буфер и завершаем
 		if nextUseStop {
@@ -809,6 +1162,13 @@ func main() {
 			}
 		}
 
+		// Fetched pages become qa_v1 citations even if the model forgot to list them
+		if cites := fetcher.TakeCitations(); len(cites) > 0 && !tzMode && strings.HasPrefix(format, "json") {
+			if withCites, ok := addCitations(assistantOut, cites); ok {
+				assistantOut = withCites
+			}
+		}
+
 		// Сохраняем последний ответ и автосохранение ТЗ в файл при финализации
 		lastAnswer = assistantOut
 		justFinalized := tzMode && (finalizeComplete || strings.Contains(assistantOut, tzEndMarker))
@@ -820,29 +1180,45 @@ func main() {
 			}
 		}
 
+		autosave()
+
+		// Ответ уже напечатан по мере генерации
+		if streamed && assistantOut != "" {
+			continue
+		}
 		if assistantOut == "" {
 			assistantOut = "(нет ответа)"
 		}
 		// If JSON expected, try to pretty print/validate
-		if strings.HasPrefix(format, "json") {
+		invalidJSON := false
+		if jsonAnswer && assistantOut != "(нет ответа)" {
 			if pretty, ok := tryPrettyJSON(assistantOut); ok {
 				assistantOut = pretty
+			} else {
+				// a finalized TZ ends with the stop marker, which is not JSON
+				invalidJSON = !tzMode
 			}
 		}
 		fmt.Printf("Agent> %s\n", assistantOut)
+		if invalidJSON {
+			fmt.Println("[предупреждение: ответ не является корректным JSON]")
+		}
 	}
 }
 
-func selectModel(token string, reader *bufio.Reader) string {
+func selectModel(p provider.Provider, reader *bufio.Reader) string {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	models, err := openrouter.ListModels(ctx, token)
+	models, err := p.ListModels(ctx)
 	if err != nil || len(models) == 0 {
 		fmt.Println("Не удалось получить список моделей. Введите ID модели вручную (пример: openrouter/auto):")
 		fmt.Print("Модель: ")
 		line, _ := reader.ReadString('\n')
 		return strings.TrimSpace(line)
 	}
+	if p.Name() != "openrouter" {
+		return selectFromList(models, reader)
+	}
 
 	// Рекомендуемые модели (предпочтения и стабильность JSON/tool-use)
 	recommended := []string{
@@ -858,11 +1234,11 @@ func selectModel(token string, reader *bufio.Reader) string {
 	// Разделим модели: бесплатные (по признаку ":free" в ID) и платные
 	var freeIDs []string
 	var paidIDs []string
-	for _, m := range models {
-		if strings.Contains(m.ID, ":free") {
-			freeIDs = append(freeIDs, m.ID)
+	for _, id := range models {
+		if strings.Contains(id, ":free") {
+			freeIDs = append(freeIDs, id)
 		} else {
-			paidIDs = append(paidIDs, m.ID)
+			paidIDs = append(paidIDs, id)
 		}
 	}
 
@@ -925,15 +1301,155 @@ func selectModel(token string, reader *bufio.Reader) string {
 	return line
 }
 
+// selectFromList shows a numbered list of model IDs; Enter picks the first one.
+func selectFromList(ids []string, reader *bufio.Reader) string {
+	fmt.Println("Доступные модели:")
+	for i, id := range ids {
+		fmt.Printf("%2d) %s\n", i+1, id)
+	}
+	fmt.Printf("Введите номер из списка или полный ID (Enter по умолчанию: %s):\n", ids[0])
+	fmt.Print("Модель: ")
+	line, _ := reader.ReadString('\n')
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return ids[0]
+	}
+	if n, err := strconv.Atoi(line); err == nil && n >= 1 && n <= len(ids) {
+		return ids[n-1]
+	}
+	return line
+}
+
 func printHelp() {
 	fmt.Println("Доступные команды:")
 	fmt.Println("  /help                      — показать эту справку")
 	fmt.Println("  /format <text|markdown|json> — сменить формат ответа")
 	fmt.Println("  /tz on|off|finalize       — режим подготовки ТЗ и финализация по маркеру")
 	fmt.Println("  /save [path]              — сохранить последний ответ в файл")
+	fmt.Println("  /provider <openrouter|hf|ollama> — сменить провайдера (REPL, /bench, /temps, /pair, /chaincheck)")
+	fmt.Println("  /provider custom <url> [key] — OpenAI-совместимый сервер (llama.cpp, vLLM, LM Studio)")
+	fmt.Println("  /model [id]                — показать/сменить модель текущего провайдера")
+	fmt.Println("  /models                    — модели текущего провайдера")
+	fmt.Println("  /stream on|off             — печатать ответ по мере генерации")
+	fmt.Println("  /tools [on|off <name>]     — список инструментов, включить/выключить")
+	fmt.Println("  /policy [<name> allow|deny|ask] — политики вызова инструментов")
+	fmt.Println("  /mcp                       — подключённые MCP-серверы и их инструменты")
+	fmt.Println("  /session save|load|list|rm [name] — сохранённые сессии; запуск с --resume [name] продолжает сессию")
+	fmt.Println("  /retries <n>               — число попыток при 429/5xx (с экспоненциальной задержкой)")
 	fmt.Println("  exit | quit                — выйти")
 }
 
+// httpTransport is shared by the model clients and fetch_url, so they use one
+// connection pool and the same proxy settings (HTTPS_PROXY, HTTP_PROXY, NO_PROXY).
+var httpTransport = http.DefaultTransport.(*http.Transport).Clone()
+
+// newFetchClient builds the client of fetch_url on the shared transport; the
+// fetcher adds its check of local addresses to it. FETCH_TIMEOUT (e.g. 45s)
+// limits the download of a page, 30s by default.
+func newFetchClient() *http.Client {
+	timeout := 30 * time.Second
+	if v := strings.TrimSpace(os.Getenv("FETCH_TIMEOUT")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			fmt.Printf("Некорректный FETCH_TIMEOUT=%s, используется %s\n", v, timeout)
+		} else {
+			timeout = d
+		}
+	}
+	return &http.Client{Transport: httpTransport, Timeout: timeout}
+}
+
+// newOpenRouterClient builds the OpenRouter client. OPENROUTER_BASE_URL points it at a
+// local stand-in server, a corporate proxy or a self-hosted gateway.
+func newOpenRouterClient(token string) *openrouter.Client {
+	opts := []openrouter.Option{
+		openrouter.WithToken(token),
+		openrouter.WithHeader("X-Title", "agent_challenge"),
+	}
+	if u := strings.TrimSpace(os.Getenv("OPENROUTER_BASE_URL")); u != "" {
+		opts = append(opts, openrouter.WithBaseURL(u))
+	}
+	if ref := strings.TrimSpace(os.Getenv("OPENROUTER_REFERER")); ref != "" {
+		opts = append(opts, openrouter.WithHeader("HTTP-Referer", ref))
+	}
+	return openrouter.NewClient(append(opts, openrouter.WithTransport(httpTransport))...)
+}
+
+// newHuggingFaceClient builds the HF client. HUGGINGFACE_BASE_URL and HUGGINGFACE_HUB_URL
+// override the Inference API and Hub endpoints.
+func newHuggingFaceClient(token string) *huggingface.Client {
+	opts := []huggingface.Option{huggingface.WithToken(token), huggingface.WithTransport(httpTransport)}
+	if u := strings.TrimSpace(os.Getenv("HUGGINGFACE_BASE_URL")); u != "" {
+		opts = append(opts, huggingface.WithBaseURL(u))
+	}
+	if u := strings.TrimSpace(os.Getenv("HUGGINGFACE_HUB_URL")); u != "" {
+		opts = append(opts, huggingface.WithHubURL(u))
+	}
+	return huggingface.NewClient(opts...)
+}
+
+// newOllamaClient builds the client for a local Ollama server; OLLAMA_HOST overrides the address.
+func newOllamaClient() *ollama.Client {
+	opts := []ollama.Option{ollama.WithTransport(httpTransport)}
+	if h := strings.TrimSpace(os.Getenv("OLLAMA_HOST")); h != "" {
+		opts = append(opts, ollama.WithBaseURL(h))
+	}
+	return ollama.NewClient(opts...)
+}
+
+// newCustomClient builds a client for an arbitrary OpenAI-compatible server.
+func newCustomClient(rawURL, key string) *openrouter.Client {
+	return openrouter.NewClient(
+		openrouter.WithName("custom"),
+		openrouter.WithBaseURL(provider.OpenAIBaseURL(rawURL)),
+		openrouter.WithToken(strings.TrimSpace(key)),
+		openrouter.WithTransport(httpTransport),
+		// local servers may take long to load or run a model
+		openrouter.WithTimeout(5*time.Minute),
+	)
+}
+
+// chatRequest builds a chat request with the session's sampling and answer format settings.
+func chatRequest(model string, messages []openrouter.ChatMessage, maxTokens int, temperature float64, format string) openrouter.ChatCompletionRequest {
+	req := openrouter.ChatCompletionRequest{Model: model, Messages: messages, MaxTokens: maxTokens, Temperature: temperature}
+	// hint model to return JSON if format requires it
+	if strings.HasPrefix(format, "json") {
+		req.ResponseFormat = map[string]any{"type": "json_object"}
+	}
+	return req
+}
+
+// trimHistory drops the older half of the conversation, keeping the first and the latest
+// system prompts. The cut is moved forward to a user message so tool results never lose
+// the assistant message that requested them.
+func trimHistory(messages []openrouter.ChatMessage) []openrouter.ChatMessage {
+	cut := len(messages) / 2
+	for cut < len(messages) && messages[cut].Role != "user" {
+		cut++
+	}
+	if cut <= 1 || cut >= len(messages) {
+		return messages
+	}
+	out := []openrouter.ChatMessage{messages[0]}
+	for i := cut - 1; i > 0; i-- {
+		if messages[i].Role == "system" {
+			out = append(out, messages[i])
+			break
+		}
+	}
+	return append(out, messages[cut:]...)
+}
+
+// providerNames returns the registered provider names in stable order.
+func providerNames(providers map[string]provider.Provider) []string {
+	names := make([]string, 0, len(providers))
+	for name := range providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func normalizeFormat(s string) string {
 	s = strings.ToLower(strings.TrimSpace(s))
 	switch s {
@@ -967,7 +1483,7 @@ func buildSystemPrompt(format string) string {
 	base := "Ты — полезный ассистент. Отвечай по-русски. Используй инструменты, когда уместно."
 	if strings.HasPrefix(format, "json") {
 		schema := `{"type":"object","required":["answer"],"properties":{"answer":{"type":"string"},"citations":{"type":"array","items":{"type":"object","required":["url"],"properties":{"url":{"type":"string"},"title":{"type":"string"}}}},"used_tools":{"type":"array","items":{"type":"object","required":["name","result"],"properties":{"name":{"type":"string"},"arguments":{"type":"object"},"result":{"type":"string"}}}},"followups":{"type":"array","items":{"type":"string"}}}}`
-		return base + " Всегда возвращай ТОЛЬКО валидный JSON по схеме qa_v1 без текста вокруг. Схема qa_v1: " + schema
+		return base + " Всегда возвращай ТОЛЬКО валидный JSON по схеме qa_v1 без текста вокруг. Страницы, загруженные через fetch_url, перечисляй в citations. Схема qa_v1: " + schema
 	}
 	if format == "markdown" {
 		return base + " Отвечай в Markdown (заголовки, списки, ссылки)."
@@ -977,7 +1493,7 @@ func buildSystemPrompt(format string) string {
 
 func buildTZSystemPrompt(format string) string {
 	// Сжатая инструкция BA-режима с маркером финала
-	base := "Ты — бизнес-аналитик. Сначала собираешь требования, затем оформляешь полное ТЗ. Работаешь циклами: задать до 3 уточняющих вопросов → обновить черновик секций → проверить чек-лист полноты → запросить подтверждение → итог. Если пользователь напишет ‘Утвердить’ или не ответит два шага подряд, выдай финальный результат. Финальный ответ строго заканчивай строкой END_OF_TZ. Всегда отвечай по-русски. Не раскрывай внутренние рассуждения."
+	base := "Ты — бизнес-аналитик. Сначала собираешь требования, затем оформляешь полное ТЗ. Работаешь циклами: задать до 3 уточняющих вопросов → обновить черновик секций → проверить чек-лист полноты → запросить подтверждение → итог. Если пользователь напишет ‘Утвердить’ или не ответит два шага подряд, выдай финальный результат. Финальный ответ строго заканчивай строкой END_OF_TZ. Всегда отвечай по-русски. Не раскрывай внутренние рассуждения. Существующие ТЗ и спецификации (TZ_*.md) можно найти и прочитать инструментами list_dir, grep и read_file, а обновить раздел на месте — через apply_patch (пользователь подтверждает запись)."
 	if strings.HasPrefix(format, "json") {
 		// В ТЗ-режиме JSON может использоваться для структурированного итога
 		return base + " Если запрошен JSON-формат, возвращай валидный JSON по запрошенной схеме без текста вокруг."
@@ -1008,6 +1524,273 @@ func tryParseLinearEq(s string) (bool, int, int, int) {
 	return true, a, b, c
 }
 
+// replApprover shows file changes proposed by the model as a diff and asks the
+// user to apply, reject or edit them before anything is written.
+type replApprover struct {
+	reader *bufio.Reader
+}
+
+func (a replApprover) ApproveWrite(req agent.WriteRequest) agent.Approval {
+	action := "изменить"
+	if req.Old == nil {
+		action = "создать"
+	}
+	fmt.Printf("\nМодель предлагает %s файл %s (%s):\n", action, req.Path, req.Tool)
+	printDiff(req.Diff)
+	content := req.Content
+	for {
+		fmt.Print("Применить? [y] да / [n] нет / [e] редактировать: ")
+		line, err := a.reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			return agent.Approval{Reason: "нет ответа пользователя"}
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes", "д", "да":
+			return agent.Approval{Approved: true, Content: content}
+		case "n", "no", "н", "нет":
+			fmt.Print("Комментарий для модели (Enter — без комментария): ")
+			reason, _ := a.reader.ReadString('\n')
+			return agent.Approval{Reason: strings.TrimSpace(reason)}
+		case "e", "edit", "р":
+			edited, err := editInEditor(req.Path, content)
+			if err != nil {
+				fmt.Printf("Не удалось открыть редактор: %v\n", err)
+				continue
+			}
+			content = edited
+			fmt.Println("Итоговые изменения:")
+			printDiff(agent.UnifiedDiff(req.Path, req.Old, content))
+		}
+	}
+}
+
+func (a replApprover) ApproveCommand(req agent.CommandRequest) agent.CommandApproval {
+	dir := req.Dir
+	if dir == "." {
+		dir = "корень рабочей папки"
+	}
+	fmt.Printf("\nМодель хочет выполнить команду (%s):\n  $ %s\n", dir, req.Command)
+	for {
+		fmt.Print("Выполнить? [y] да / [a] да, и больше не спрашивать в этой сессии / [n] нет: ")
+		line, err := a.reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			return agent.CommandApproval{Reason: "нет ответа пользователя"}
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes", "д", "да":
+			return agent.CommandApproval{Approved: true}
+		case "a", "always", "в", "всегда":
+			return agent.CommandApproval{Approved: true, Always: true}
+		case "n", "no", "н", "нет":
+			fmt.Print("Комментарий для модели (Enter — без комментария): ")
+			reason, _ := a.reader.ReadString('\n')
+			return agent.CommandApproval{Reason: strings.TrimSpace(reason)}
+		}
+	}
+}
+
+func (a replApprover) ApproveTool(req agent.ToolRequest) agent.ToolApproval {
+	args := string(req.Arguments)
+	if pretty, ok := tryPrettyJSON(args); ok {
+		args = pretty
+	}
+	fmt.Printf("\nМодель вызывает инструмент %s с аргументами:\n%s\n", req.Tool, args)
+	for {
+		fmt.Print("Разрешить? [y] да / [a] да, и больше не спрашивать в этой сессии / [n] нет: ")
+		line, err := a.reader.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Println()
+			return agent.ToolApproval{Reason: "нет ответа пользователя"}
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes", "д", "да":
+			return agent.ToolApproval{Approved: true}
+		case "a", "always", "в", "всегда":
+			return agent.ToolApproval{Approved: true, Always: true}
+		case "n", "no", "н", "нет":
+			fmt.Print("Комментарий для модели (Enter — без комментария): ")
+			reason, _ := a.reader.ReadString('\n')
+			return agent.ToolApproval{Reason: strings.TrimSpace(reason)}
+		}
+	}
+}
+
+// loadToolPolicy reads the policy file from TOOL_POLICY, or tool_policy.json if it exists.
+// Without a file every built-in tool is allowed, MCP tools are asked about and
+// decisions are not logged.
+func loadToolPolicy() (*agent.Policy, error) {
+	file := strings.TrimSpace(os.Getenv("TOOL_POLICY"))
+	if file == "" {
+		file = "tool_policy.json"
+		if _, err := os.Stat(file); err != nil {
+			return &agent.Policy{}, nil
+		}
+	}
+	cfg, err := agent.LoadPolicyConfig(file)
+	if err != nil {
+		return nil, err
+	}
+	return &agent.Policy{Config: cfg}, nil
+}
+
+// sessionsDir is the directory of saved sessions: AGENT_SESSIONS_DIR or ./sessions.
+func sessionsDir() string {
+	if d := strings.TrimSpace(os.Getenv("AGENT_SESSIONS_DIR")); d != "" {
+		return d
+	}
+	return "sessions"
+}
+
+// resumeFlag finds --resume, --resume <name> or --resume=<name> in the arguments.
+// An empty name means the most recently saved session.
+func resumeFlag(args []string) (string, bool) {
+	for i, a := range args {
+		switch {
+		case a == "--resume" || a == "-resume":
+			if i+1 < len(args) && !strings.HasPrefix(args[i+1], "-") {
+				return args[i+1], true
+			}
+			return "", true
+		case strings.HasPrefix(a, "--resume="):
+			return strings.TrimPrefix(a, "--resume="), true
+		case strings.HasPrefix(a, "-resume="):
+			return strings.TrimPrefix(a, "-resume="), true
+		}
+	}
+	return "", false
+}
+
+// lastSystemPrompt returns the latest system message of a history: /format and /tz
+// append a new one instead of replacing the first.
+func lastSystemPrompt(messages []openrouter.ChatMessage, fallback string) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role == "system" {
+			return messages[i].Content
+		}
+	}
+	return fallback
+}
+
+// startMCPServers starts the servers listed in MCP_CONFIG, or mcp.json if it exists,
+// and registers their tools. A server that fails to start is reported and skipped.
+func startMCPServers(tools *agent.Registry) []*mcp.Client {
+	file := strings.TrimSpace(os.Getenv("MCP_CONFIG"))
+	if file == "" {
+		file = "mcp.json"
+		if _, err := os.Stat(file); err != nil {
+			return nil
+		}
+	}
+	configs, err := mcp.LoadConfig(file)
+	if err != nil {
+		fmt.Printf("MCP: %v\n", err)
+		return nil
+	}
+	var clients []*mcp.Client
+	for _, cfg := range configs {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		c, err := mcp.Start(ctx, cfg)
+		if err == nil {
+			var names []string
+			names, err = agent.RegisterMCPTools(ctx, tools, c)
+			if err == nil || len(names) > 0 {
+				fmt.Printf("MCP %s: инструментов %d\n", cfg.Name, len(names))
+			}
+			clients = append(clients, c)
+		}
+		cancel()
+		if err != nil {
+			fmt.Printf("MCP %s: %v\n", cfg.Name, err)
+		}
+	}
+	return clients
+}
+
+// commandAllowlist reads comma-separated command patterns from AGENT_ALLOW_COMMANDS.
+// By default only commands that just look at the workspace are pre-approved.
+func commandAllowlist() []string {
+	env, ok := os.LookupEnv("AGENT_ALLOW_COMMANDS")
+	if !ok {
+		return []string{"ls *", "pwd", "git status *"}
+	}
+	var allow []string
+	for _, p := range strings.Split(env, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			allow = append(allow, p)
+		}
+	}
+	return allow
+}
+
+// showCommandResult prints the head of a run_command result so the user sees what ran.
+func showCommandResult(result string) {
+	const maxLines = 30
+	lines := strings.Split(strings.TrimRight(result, "\n"), "\n")
+	fmt.Println()
+	for i, l := range lines {
+		if i == maxLines {
+			fmt.Printf("\x1b[2m… ещё %d строк\x1b[0m\n", len(lines)-maxLines)
+			break
+		}
+		fmt.Printf("\x1b[2m%s\x1b[0m\n", l)
+	}
+}
+
+// printDiff prints a unified diff with removed lines in red and added lines in green.
+func printDiff(diff string) {
+	if diff == "" {
+		fmt.Println("(без изменений)")
+		return
+	}
+	for _, l := range strings.SplitAfter(strings.TrimSuffix(diff, "\n"), "\n") {
+		l = strings.TrimSuffix(l, "\n")
+		switch {
+		case strings.HasPrefix(l, "+++"), strings.HasPrefix(l, "---"):
+			fmt.Printf("\x1b[1m%s\x1b[0m\n", l)
+		case strings.HasPrefix(l, "@@"):
+			fmt.Printf("\x1b[36m%s\x1b[0m\n", l)
+		case strings.HasPrefix(l, "+"):
+			fmt.Printf("\x1b[32m%s\x1b[0m\n", l)
+		case strings.HasPrefix(l, "-"):
+			fmt.Printf("\x1b[31m%s\x1b[0m\n", l)
+		default:
+			fmt.Println(l)
+		}
+	}
+}
+
+// editInEditor opens content in $VISUAL or $EDITOR (default vi) and returns the saved text.
+func editInEditor(path string, content []byte) ([]byte, error) {
+	editor := strings.TrimSpace(os.Getenv("VISUAL"))
+	if editor == "" {
+		editor = strings.TrimSpace(os.Getenv("EDITOR"))
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	f, err := os.CreateTemp("", "agent-edit-*"+filepath.Ext(path))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	args := strings.Fields(editor)
+	cmd := exec.Command(args[0], append(args[1:], f.Name())...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f.Name())
+}
+
 func saveFinalTZ(content, format string) (string, error) {
 	ext := "md"
 	if strings.HasPrefix(format, "json") {
@@ -1020,6 +1803,45 @@ func saveFinalTZ(content, format string) (string, error) {
 	return name, nil
 }
 
+// addCitations appends the cites missing from the citations of a qa_v1 answer.
+// It reports false if the answer is not a JSON object or already cites them all.
+func addCitations(answer string, cites []agent.Citation) (string, bool) {
+	var obj map[string]any
+	if err := json.Unmarshal([]byte(answer), &obj); err != nil || obj == nil {
+		return answer, false
+	}
+	list, _ := obj["citations"].([]any)
+	seen := map[string]bool{}
+	for _, c := range list {
+		if m, ok := c.(map[string]any); ok {
+			if u, ok := m["url"].(string); ok {
+				seen[u] = true
+			}
+		}
+	}
+	added := false
+	for _, c := range cites {
+		if seen[c.URL] {
+			continue
+		}
+		item := map[string]any{"url": c.URL}
+		if c.Title != "" {
+			item["title"] = c.Title
+		}
+		list = append(list, item)
+		added = true
+	}
+	if !added {
+		return answer, false
+	}
+	obj["citations"] = list
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return answer, false
+	}
+	return string(b), true
+}
+
 func tryPrettyJSON(s string) (string, bool) {
 	var v any
 	if err := json.Unmarshal([]byte(s), &v); err != nil {
@@ -1073,8 +1895,11 @@ func startSpinner(message string) func() {
 			}
 		}
 	}()
+	var once sync.Once
 	return func() {
-		close(done)
-		fmt.Print("\r\x1b[2K") // clear line
+		once.Do(func() {
+			close(done)
+			fmt.Print("\r\x1b[2K") // clear line
+		})
 	}
 }